@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultReadyTimeout bounds the time a reload waits for the new server to
+// become ready when health.startup-timeout is not set, so that a server that
+// never does cannot hold up later reloads.
+const defaultReadyTimeout = 30 * time.Minute
+
+// backend is one generation of llama-server in blue/green mode, listening on
+// a temporary port behind the front proxy.
+type backend struct {
+	child  *child
+	proxy  *httputil.ReverseProxy
+	health *healthCheck
+	// drainTimeout is how long the backend may take to finish its requests
+	// once it has been replaced, or 0 to wait as long as they take.
+	drainTimeout time.Duration
+	// inflight counts the requests being proxied to the backend. Requests
+	// are only added while it is active.
+	inflight sync.WaitGroup
+	stopOnce sync.Once
+	// exited is closed once the backend has exited for good, after which
+	// err describes how.
+	exited chan struct{}
+	err    error
+}
+
+// blueGreen runs a single llama-server behind a proxy on its configured port.
+// A reload starts the new server on a temporary port, switches the proxy over
+// once it is ready, and then drains and stops the old one, so that clients
+// see no downtime.
+type blueGreen struct {
+	sup       *supervisor
+	transport http.RoundTripper
+	// switched receives a value whenever another backend becomes active.
+	switched chan struct{}
+	// readyTimeout bounds the wait for a new backend to become ready when
+	// its health check has no startup timeout.
+	readyTimeout time.Duration
+
+	// mu guards the fields below.
+	mu        sync.Mutex
+	active    *backend
+	backends  []*backend
+	reloading bool
+	closed    bool
+}
+
+// newBlueGreen creates the blue/green runner for a supervisor.
+func newBlueGreen(sup *supervisor) *blueGreen {
+	return &blueGreen{sup: sup, transport: upstreamTransport(), switched: make(chan struct{}, 1), readyTimeout: defaultReadyTimeout}
+}
+
+// ServeHTTP proxies a request to the active backend.
+func (bg *blueGreen) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bg.mu.Lock()
+	b := bg.active
+	b.inflight.Add(1)
+	bg.mu.Unlock()
+	defer b.inflight.Done()
+	b.proxy.ServeHTTP(w, r)
+}
+
+// launch starts llama-server on a free local port, in place of the host and
+// port of its configuration, and supervises it until it exits for good.
+func (bg *blueGreen) launch(srv server) (*backend, error) {
+	port, err := freeLocalPort()
+	if err != nil {
+		return nil, err
+	}
+	config := *srv.config
+	config.Host, config.Port = "127.0.0.1", port
+	// llama-server uses the last value given for an option, so these
+	// also override any --host or --port among the passthrough arguments.
+	args := append(slices.Clone(srv.args), "--host", config.Host, "--port", strconv.Itoa(port))
+
+	c := bg.sup.newChild(server{name: srv.name, config: &config, args: args})
+	target := upstreamURL(&config)
+	// Clients already include any API prefix in their requests.
+	target.Path = ""
+	b := &backend{
+		child:        c,
+		health:       newHealthCheck(&config),
+		drainTimeout: parseDurationOr(config.ShutdownTimeout, 0),
+		exited:       make(chan struct{}),
+		proxy: &httputil.ReverseProxy{
+			Rewrite: func(r *httputil.ProxyRequest) {
+				r.SetURL(target)
+				r.SetXForwarded()
+			},
+			Transport:     bg.transport,
+			FlushInterval: -1,
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				writeAPIError(w, http.StatusBadGateway, "server_error", fmt.Sprintf("llama-server is not available: %v", err))
+			},
+		},
+	}
+
+	bg.mu.Lock()
+	defer bg.mu.Unlock()
+	if bg.closed {
+		return nil, errStopping
+	}
+	// The supervisor knows of the child before it starts, so that the
+	// reaper leaves it to exec once it exits.
+	bg.backends = append(bg.backends, b)
+	bg.publish()
+	if err := c.start(); err != nil {
+		bg.backends = bg.backends[:len(bg.backends)-1]
+		bg.publish()
+		return nil, err
+	}
+	go func() {
+		b.err = bg.sup.supervise(c)
+		bg.sup.reportExit(c, b.err)
+		bg.mu.Lock()
+		bg.backends = slices.DeleteFunc(bg.backends, func(other *backend) bool { return other == b })
+		bg.publish()
+		bg.mu.Unlock()
+		close(b.exited)
+	}()
+	return b, nil
+}
+
+// publish shows the supervisor the active child, and the others that are
+// still running. It must be called with bg.mu held.
+func (bg *blueGreen) publish() {
+	var children, replacing []*child
+	for _, b := range bg.backends {
+		if b == bg.active {
+			children = append(children, b.child)
+		} else {
+			replacing = append(replacing, b.child)
+		}
+	}
+	if bg.active == nil {
+		children, replacing = replacing, nil
+	}
+	bg.sup.setChildren(children, replacing)
+}
+
+// current returns the active backend.
+func (bg *blueGreen) current() *backend {
+	bg.mu.Lock()
+	defer bg.mu.Unlock()
+	return bg.active
+}
+
+// reload loads the configuration again and, if llama-server's command
+// changed, starts the new server alongside the running one and switches to
+// it once it is ready. If the new configuration is invalid, or the new
+// server does not become ready, the running server is kept.
+func (bg *blueGreen) reload(trigger string) {
+	s := bg.sup
+	bg.mu.Lock()
+	if bg.closed {
+		bg.mu.Unlock()
+		return
+	}
+	if bg.reloading {
+		bg.mu.Unlock()
+		slog.Warn("A reload is already in progress, ignoring this one", "event", "reload", "trigger", trigger)
+		return
+	}
+	bg.reloading = true
+	bg.mu.Unlock()
+	defer func() {
+		bg.mu.Lock()
+		bg.reloading = false
+		bg.mu.Unlock()
+	}()
+
+	slog.Info("Reloading the configuration", "event", "reload", "trigger", trigger)
+	config, servers, err := s.reload()
+	if err == nil {
+		err = s.checkReload(config, servers)
+	}
+	if err == nil {
+		err = checkFront(s.config, config)
+	}
+	if err != nil {
+		slog.Error("Keeping the running configuration", "event", "reload", "error", err)
+		return
+	}
+	if slices.Equal(servers[0].args, s.servers[0].args) {
+		slog.Info("No llama-server command changed, nothing to restart", "event", "reload")
+		return
+	}
+
+	green, err := bg.launch(servers[0])
+	if errors.Is(err, errStopping) {
+		return
+	}
+	if err != nil {
+		slog.Error("Keeping the running llama-server, as the new one could not be started", "event", "reload", "error", err)
+		return
+	}
+	slog.Info("Started the new llama-server, waiting for it to become ready", "event", "start", "pid", green.child.pid())
+	if err := bg.waitReady(green); err != nil {
+		slog.Error("Keeping the running llama-server, as the new one is not ready", "event", "reload", "pid", green.child.pid(), "error", err)
+		bg.stop(green, syscall.SIGTERM)
+		return
+	}
+
+	bg.mu.Lock()
+	if bg.closed {
+		bg.mu.Unlock()
+		return
+	}
+	blue := bg.active
+	bg.active = green
+	bg.publish()
+	bg.mu.Unlock()
+	s.config, s.servers = config, servers
+	s.mu.Lock()
+	s.configHash = configHash(servers)
+	s.mu.Unlock()
+	select {
+	case bg.switched <- struct{}{}:
+	default:
+	}
+
+	slog.Info("Switched to the new llama-server, draining the old one", "event", "switch", "pid", green.child.pid(), "old_pid", blue.child.pid())
+	bg.drain(blue)
+}
+
+// waitReady waits for a new backend's /health endpoint to report that it is
+// ready, giving up if it exits or exceeds its health check's startup timeout,
+// or the default one.
+func (bg *blueGreen) waitReady(b *backend) error {
+	h := b.health
+	limit := h.startupTimeout
+	if limit == 0 {
+		limit = bg.readyTimeout
+	}
+	timeout := time.NewTimer(limit)
+	defer timeout.Stop()
+	ticker := time.NewTicker(healthPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.exited:
+			return fmt.Errorf("it exited before it was ready: %s", strings.TrimSuffix(describeExit(b.err), "."))
+		case <-timeout.C:
+			return fmt.Errorf("it was not ready within %s", limit)
+		case <-ticker.C:
+		}
+		if state, _ := h.probe(context.Background()); state == healthReady {
+			return nil
+		}
+	}
+}
+
+// drain waits for the requests in flight to a replaced backend to finish,
+// for up to its drain timeout, and then stops it.
+func (bg *blueGreen) drain(b *backend) {
+	drained := make(chan struct{})
+	go func() {
+		b.inflight.Wait()
+		close(drained)
+	}()
+	var timeout <-chan time.Time
+	if b.drainTimeout > 0 {
+		timer := time.NewTimer(b.drainTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-drained:
+	case <-b.exited:
+	case <-timeout:
+		slog.Warn("The old llama-server is still serving requests, stopping it anyway", "event", "switch", "pid", b.child.pid(), "timeout", b.drainTimeout.String())
+	}
+	bg.stop(b, syscall.SIGTERM)
+}
+
+// stop stops a backend gracefully with the given signal, and waits for it
+// to exit.
+func (bg *blueGreen) stop(b *backend, sig syscall.Signal) {
+	b.stopOnce.Do(func() {
+		b.child.markStopping()
+		if err := bg.sup.terminate(b.child, sig); err != nil {
+			slog.Debug("Failed to send signal", "event", "signal", "pid", b.child.pid(), "signal", signalName(sig), "error", err)
+		}
+	})
+	<-b.exited
+}
+
+// shutdown stops every backend with the given signal, and returns the exit
+// code of the active one.
+func (bg *blueGreen) shutdown(sig syscall.Signal) int {
+	bg.mu.Lock()
+	bg.closed = true
+	active := bg.active
+	backends := slices.Clone(bg.backends)
+	bg.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, b := range backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bg.stop(b, sig)
+		}()
+	}
+	wg.Wait()
+	<-active.exited
+	return exitCode(active.err)
+}
+
+// forward sends a signal to every running backend.
+func (bg *blueGreen) forward(sig syscall.Signal) {
+	bg.mu.Lock()
+	backends := slices.Clone(bg.backends)
+	bg.mu.Unlock()
+	for _, b := range backends {
+		slog.Debug("Forwarding signal to llama-server", "event", "signal", "pid", b.child.pid(), "signal", signalName(sig))
+		b.child.signal(sig)
+	}
+}
+
+// checkFront checks that a reloaded configuration keeps the address and TLS
+// settings of the front proxy, which keeps listening while servers change.
+func checkFront(old, config *LlamaConfig) error {
+	if frontAddress(config) != frontAddress(old) || config.SslCertFile != old.SslCertFile || config.SslKeyFile != old.SslKeyFile {
+		return fmt.Errorf("host, port, ssl-cert-file and ssl-key-file cannot change without restarting llauncher")
+	}
+	return nil
+}
+
+// frontAddress returns the address llama-server would listen on, which the
+// front proxy listens on instead.
+func frontAddress(config *LlamaConfig) string {
+	host := config.Host
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(effectivePort(config)))
+}
+
+// freeLocalPort returns a local port that is free to listen on.
+func freeLocalPort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("could not find a free port: %w", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// runBlueGreen runs the single server of a configuration in blue/green mode,
+// reloading it without downtime on SIGHUP, until llauncher is told to stop.
+func runBlueGreen(config *LlamaConfig, sup *supervisor) int {
+	// Subscribe before the server starts, so that no signal is missed.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, forwardedSignals...)
+	defer signal.Stop(sigChan)
+
+	// Listen before starting llama-server, so that a port in use fails
+	// fast.
+	listen := frontAddress(config)
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		slog.Error("Could not start the blue/green proxy", "event", "listen", "error", err)
+		return 1
+	}
+	if config.SslCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.SslCertFile, config.SslKeyFile)
+		if err != nil {
+			listener.Close()
+			slog.Error("Could not start the blue/green proxy", "event", "listen", "error", err)
+			return 1
+		}
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+
+	bg := newBlueGreen(sup)
+	active, err := bg.launch(sup.servers[0])
+	if err != nil {
+		listener.Close()
+		slog.Error("Failed to run llama-server", "event", "start", "error", err)
+		return 1
+	}
+	bg.mu.Lock()
+	bg.active = active
+	bg.publish()
+	bg.mu.Unlock()
+
+	srv := &http.Server{Handler: bg}
+	go srv.Serve(listener)
+	defer srv.Close()
+	slog.Debug("Blue/green proxy listening", "event", "listen", "address", listen)
+
+	for {
+		select {
+		case sig := <-sigChan:
+			switch {
+			case isTermination(sig):
+				return bg.shutdown(sig.(syscall.Signal))
+			case sig == syscall.SIGHUP:
+				go bg.reload("SIGHUP")
+			default:
+				bg.forward(sig.(syscall.Signal))
+			}
+		case path := <-sup.changes:
+			go bg.reload(path)
+		case <-bg.switched:
+			active = bg.current()
+		case <-active.exited:
+			if current := bg.current(); current != active {
+				active = current
+				continue
+			}
+			// The active server exited for good, so llauncher exits with
+			// its status, after stopping any server replacing it.
+			bg.shutdown(syscall.SIGTERM)
+			return exitCode(active.err)
+		}
+	}
+}
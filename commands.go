@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// validationReport is the result of validating a single configuration file.
+type validationReport struct {
+	Path   string        `json:"path"`
+	Valid  bool          `json:"valid"`
+	Errors []configError `json:"errors,omitempty"`
+}
+
+// runValidate implements the "validate" subcommand. It loads and validates
+// each configuration file named on the command line, or every *.yaml and
+// *.yml file in a named directory, without starting llama-server. It returns
+// 0 if every file is valid, 1 if any is not, and 2 for usage errors.
+func runValidate(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("llauncher validate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "text", "Output format: text or json")
+	noStrict := flags.Bool("no-strict", false, "Ignore unknown keys in the configuration files")
+	noFileChecks := flags.Bool("no-file-checks", false, "Do not check that local files, such as models, exist")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage:")
+		fmt.Fprintln(stderr, "  llauncher validate [--format text|json] [--no-strict] [--no-file-checks] [<file|directory>...]")
+		fmt.Fprintln(stderr, "\nWith no files, the configuration file llauncher would use is validated.")
+		fmt.Fprintln(stderr, "\nOptions:")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(stderr, "Unknown output format: %s\n", *format)
+		return 2
+	}
+
+	targets := flags.Args()
+	if len(targets) == 0 {
+		targets = []string{resolveConfigPath()}
+	}
+	paths, err := expandConfigPaths(targets)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 2
+	}
+
+	opts := loadOptions{Strict: !*noStrict}
+	reports := make([]validationReport, 0, len(paths))
+	exitCode := 0
+	for _, path := range paths {
+		report := validateFile(path, opts, !*noFileChecks)
+		if !report.Valid {
+			exitCode = 1
+		}
+		reports = append(reports, report)
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			fmt.Fprintf(stderr, "Failed to write report: %v\n", err)
+			return 2
+		}
+		return exitCode
+	}
+
+	for _, report := range reports {
+		if report.Valid {
+			fmt.Fprintf(stdout, "%s: OK\n", report.Path)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s: %d problem(s)\n", report.Path, len(report.Errors))
+		for _, e := range report.Errors {
+			fmt.Fprintf(stdout, "  %v\n", e)
+		}
+	}
+	return exitCode
+}
+
+// validateFile loads and validates one configuration file, and each of the
+// profiles it defines. Every problem is reported against the file, whether it
+// came from loading or validation. Problems that only occur with a profile
+// are reported against the keys of that profile. Local files are only checked
+// to exist if checkFiles is set.
+func validateFile(path string, opts loadOptions, checkFiles bool) validationReport {
+	report := validationReport{Path: path}
+
+	config, err := loadConfigWithOptions(path, opts)
+	if err == nil {
+		err = validateConfigFiles(config, checkFiles)
+	}
+	base := fileErrors(path, err)
+	report.Errors = base
+
+	if config != nil {
+		for _, name := range config.profiles {
+			profileOpts := opts
+			profileOpts.Profile = name
+			profileConfig, err := loadConfigWithOptions(path, profileOpts)
+			if err == nil {
+				err = validateConfigFiles(profileConfig, checkFiles)
+			}
+			for _, e := range fileErrors(path, err) {
+				if slices.Contains(base, e) {
+					continue
+				}
+				if e.Line == 0 {
+					if e.Key != "" {
+						e.Key = profilesKey + "." + name + "." + e.Key
+					} else {
+						e.Key = profilesKey + "." + name
+					}
+				}
+				report.Errors = append(report.Errors, e)
+			}
+		}
+	}
+
+	report.Valid = len(report.Errors) == 0
+	return report
+}
+
+// fileErrors lists the problems in an error from loading or validating a
+// configuration file, each reported against the file.
+func fileErrors(path string, err error) []configError {
+	var errs configErrors
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &errs):
+		var found []configError
+		for _, e := range errs {
+			if e.File == "" {
+				e.File = path
+			}
+			found = append(found, e)
+		}
+		return found
+	default:
+		return []configError{{File: path, Message: err.Error()}}
+	}
+}
+
+// expandConfigPaths replaces each directory in paths with the YAML files it
+// contains, in name order. Files are kept as given, even if they do not exist,
+// so that they are reported as invalid rather than skipped.
+func expandConfigPaths(paths []string) ([]string, error) {
+	var expanded []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() {
+			expanded = append(expanded, path)
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read directory: %w", err)
+		}
+		var found []string
+		for _, entry := range entries {
+			ext := strings.ToLower(filepath.Ext(entry.Name()))
+			if !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+				found = append(found, filepath.Join(path, entry.Name()))
+			}
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("no configuration files found in directory: %s", path)
+		}
+		slices.Sort(found)
+		expanded = append(expanded, found...)
+	}
+	return expanded, nil
+}
+
+// runPrintArgs implements the "print-args" subcommand. It prints the
+// llama-server command that llauncher would run, in the requested format,
+// without running it.
+func runPrintArgs(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("llauncher print-args", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var configPaths stringList
+	flags.Var(&configPaths, "config", "Path to YAML configuration file; repeat to merge overlays in order")
+	format := flags.String("format", "pretty", "Output format: pretty, shell, json, compose or dockerfile; compose and dockerfile give the arguments for an image whose entrypoint is llama-server")
+	noStrict := flags.Bool("no-strict", false, "Ignore unknown keys in the configuration file")
+	profile := flags.String("profile", "", "Name of the profile to use from the configuration")
+	noFileChecks := flags.Bool("no-file-checks", false, "Do not check that local files, such as models, exist")
+	var sets stringList
+	flags.Var(&sets, "set", "Override a configuration key, as key=value or key+=value for lists")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage:")
+		fmt.Fprintln(stderr, "  llauncher print-args [--config <config_file>] [--profile <name>] [--set key=value] [--format pretty|shell|json|compose|dockerfile] [--no-strict] [--no-file-checks] [-- <llama-server args>...]")
+		fmt.Fprintln(stderr, "\nOptions:")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	passthrough, err := passthroughArgs(args, flags.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "llauncher: %v\n", err)
+		return 2
+	}
+
+	configFiles := []string(configPaths)
+	if len(configFiles) == 0 {
+		configFiles = []string{resolveConfigPath()}
+	}
+
+	opts := loadOptions{Strict: !*noStrict, Profile: resolveProfile(*profile), NoFileChecks: *noFileChecks}
+	_, servers, err := prepareServers(configFiles, opts, sets, passthrough)
+	if err != nil {
+		fmt.Fprintf(stderr, "llauncher: %v\n", err)
+		return 1
+	}
+
+	out, err := formatServers(servers, *format)
+	if err != nil {
+		fmt.Fprintf(stderr, "llauncher: %v\n", err)
+		return 2
+	}
+	fmt.Fprint(stdout, out)
+	return 0
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// formatServers renders the llama-server command for every server in one of
+// the print-args output formats. With several servers each command is headed
+// by a comment naming its model, and the json format gives an array of
+// objects with the name and command of each.
+func formatServers(servers []server, format string) (string, error) {
+	if len(servers) == 1 && servers[0].name == "" {
+		return formatCommand(append([]string{"llama-server"}, servers[0].args...), format)
+	}
+
+	switch format {
+	case "pretty", "shell":
+		var b strings.Builder
+		for _, srv := range servers {
+			out, err := formatCommand(append([]string{"llama-server"}, srv.args...), format)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "# %s\n%s", srv.name, out)
+		}
+		return b.String(), nil
+	case "json":
+		type namedCommand struct {
+			Name    string   `json:"name"`
+			Command []string `json:"command"`
+		}
+		commands := make([]namedCommand, len(servers))
+		for i, srv := range servers {
+			commands[i] = namedCommand{Name: srv.name, Command: append([]string{"llama-server"}, srv.args...)}
+		}
+		var b strings.Builder
+		encoder := json.NewEncoder(&b)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(commands); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	case "dockerfile", "compose":
+		return "", fmt.Errorf("the %s format needs a single model, not a models list", format)
+	default:
+		return "", fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+// formatCommand renders a full command line (program name and arguments) in
+// one of the print-args output formats. The result ends with a newline. The
+// dockerfile and compose formats leave out the program name, as the
+// llama.cpp server images already run llama-server as their entrypoint.
+func formatCommand(argv []string, format string) (string, error) {
+	switch format {
+	case "pretty":
+		return argv[0] + " " + formatArgsForDisplay(argv[1:]) + "\n", nil
+	case "shell":
+		quoted := make([]string, len(argv))
+		for i, arg := range argv {
+			quoted[i] = shellQuote(arg)
+		}
+		return strings.Join(quoted, " ") + "\n", nil
+	case "json":
+		return marshalArgv(argv)
+	case "dockerfile":
+		out, err := marshalArgv(argv[1:])
+		if err != nil {
+			return "", err
+		}
+		return "CMD " + out, nil
+	case "compose":
+		var b strings.Builder
+		encoder := yaml.NewEncoder(&b)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(map[string][]string{"command": argv[1:]}); err != nil {
+			return "", err
+		}
+		if err := encoder.Close(); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	default:
+		return "", fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+// marshalArgv renders a command line as a JSON array followed by a newline,
+// without escaping HTML characters so arguments read as written.
+func marshalArgv(argv []string) (string, error) {
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(argv); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
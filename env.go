@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envExpansion records a configuration value that was expanded from
+// environment variable references, for display in debug output.
+type envExpansion struct {
+	Key      string
+	Template string
+	Value    string
+	// Secret is true when the value should not be displayed.
+	Secret bool
+}
+
+// secretKeys lists the configuration keys whose values are never displayed.
+var secretKeys = map[string]bool{
+	"api-key":  true,
+	"hf-token": true,
+}
+
+// secretArgs lists the llama-server options whose values are never displayed.
+var secretArgs = map[string]bool{
+	"--api-key":  true,
+	"--hf-token": true,
+}
+
+// expandEnvNode expands environment variable references in every scalar value
+// of a YAML node tree. Keys are left alone. Expansion happens after the YAML
+// has been parsed, so an expanded value is never re-parsed as YAML and cannot
+// change the structure of the document. The supported forms are:
+//
+//	${VAR}          the value of VAR, or empty if unset
+//	${VAR:-default} the value of VAR, or default if VAR is unset or empty
+//	${VAR:?message} the value of VAR, or an error if VAR is unset or empty
+//	$$              a literal $
+//
+// Unquoted values are re-resolved after expansion, so `port: ${PORT}` can
+// fill an integer field, while quoted values always stay strings.
+func expandEnvNode(node *yaml.Node, file string, lookup func(string) (string, bool)) ([]envExpansion, error) {
+	var expansions []envExpansion
+	var errs configErrors
+
+	var walk func(n *yaml.Node, key string)
+	walk = func(n *yaml.Node, key string) {
+		switch n.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				childKey := n.Content[i].Value
+				if key != "" {
+					childKey = key + "." + childKey
+				}
+				walk(n.Content[i+1], childKey)
+			}
+		case yaml.SequenceNode:
+			for i, item := range n.Content {
+				walk(item, fmt.Sprintf("%s[%d]", key, i))
+			}
+		case yaml.ScalarNode:
+			if !strings.Contains(n.Value, "$") {
+				return
+			}
+			value, names, err := expandEnv(n.Value, lookup)
+			if err != nil {
+				errs = append(errs, configError{File: file, Line: n.Line, Column: n.Column, Key: key, Message: err.Error()})
+				return
+			}
+			if value == n.Value {
+				return
+			}
+			expansions = append(expansions, envExpansion{
+				Key:      key,
+				Template: n.Value,
+				Value:    value,
+				Secret:   isSecret(key, names),
+			})
+			n.Value = value
+			if n.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+				n.Tag = ""
+			}
+		}
+	}
+	walk(node, "")
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return expansions, nil
+}
+
+// expandEnv expands the environment variable references in a single value,
+// returning the names of the variables it referred to.
+func expandEnv(s string, lookup func(string) (string, bool)) (string, []string, error) {
+	var b strings.Builder
+	var names []string
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				return "", nil, fmt.Errorf("unterminated variable reference in %q", s)
+			}
+			expr := s[i+2 : i+2+end]
+			value, name, err := expandEnvExpr(expr, lookup)
+			if err != nil {
+				return "", nil, err
+			}
+			names = append(names, name)
+			b.WriteString(value)
+			i += 2 + end
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String(), names, nil
+}
+
+// expandEnvExpr evaluates the text between "${" and "}".
+func expandEnvExpr(expr string, lookup func(string) (string, bool)) (string, string, error) {
+	name, op, arg := expr, "", ""
+	if i := strings.Index(expr, ":"); i >= 0 && i+1 < len(expr) && (expr[i+1] == '-' || expr[i+1] == '?') {
+		name, op, arg = expr[:i], expr[i:i+2], expr[i+2:]
+	}
+	if !isEnvName(name) {
+		return "", name, fmt.Errorf("invalid variable name in ${%s}", expr)
+	}
+
+	value, ok := lookup(name)
+	switch op {
+	case ":-":
+		if !ok || value == "" {
+			return arg, name, nil
+		}
+	case ":?":
+		if !ok || value == "" {
+			if arg == "" {
+				arg = "is not set"
+			}
+			return "", name, fmt.Errorf("%s: %s", name, arg)
+		}
+	}
+	return value, name, nil
+}
+
+// isEnvName reports whether s is a valid environment variable name.
+func isEnvName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isSecretKey reports whether the value of a configuration key is never
+// displayed. Nested keys, such as models[0].api-key, are judged by their
+// last part.
+func isSecretKey(key string) bool {
+	if i := strings.LastIndexByte(key, '.'); i >= 0 {
+		key = key[i+1:]
+	}
+	key, _, _ = strings.Cut(key, "[")
+	return secretKeys[key]
+}
+
+// isSecret reports whether a value should be redacted from display, either
+// because of the key it is set for or the names of the variables it came from.
+func isSecret(key string, envNames []string) bool {
+	if isSecretKey(key) {
+		return true
+	}
+	for _, name := range envNames {
+		upper := strings.ToUpper(name)
+		for _, word := range []string{"KEY", "TOKEN", "SECRET", "PASSWORD"} {
+			if strings.Contains(upper, word) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redactArgs returns a copy of a llama-server argument list with the values
+// of secret options replaced, for display.
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	copy(redacted, args)
+	for i := 0; i+1 < len(redacted); i++ {
+		if secretArgs[redacted[i]] {
+			redacted[i+1] = "<redacted>"
+			i++
+		}
+	}
+	return redacted
+}
+
+// envOverridePrefix starts the name of every environment variable that
+// overrides a configuration key.
+const envOverridePrefix = "LLAUNCHER_"
+
+// envOverrideName returns the environment variable that overrides the given
+// configuration key, e.g. LLAUNCHER_N_GPU_LAYERS for n-gpu-layers.
+func envOverrideName(key string) string {
+	return envOverridePrefix + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+// applyEnvOverrides sets every configuration key that has a non-empty
+// LLAUNCHER_* environment variable, replacing the value from the YAML. Lists
+// are given as comma-separated values. All invalid values are reported
+// together as a configErrors value.
+func applyEnvOverrides(config *LlamaConfig, lookup func(string) (string, bool)) error {
+	var errs configErrors
+	val := reflect.ValueOf(config).Elem()
+	typ := val.Type()
+	for i := 0; i < val.NumField(); i++ {
+		key := strings.Split(typ.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" || config.inModels && slices.Contains(topLevelKeys, key) {
+			continue
+		}
+		field := val.Field(i)
+		if !isOverridable(field) {
+			continue
+		}
+
+		name := envOverrideName(key)
+		raw, ok := lookup(name)
+		if !ok || raw == "" {
+			continue
+		}
+		if err := checkSharedOverride(config, key); err != nil {
+			errs = append(errs, configError{Key: name, Message: err.Error()})
+			continue
+		}
+
+		if err := setOverride(field, raw); err != nil {
+			errs = append(errs, configError{Key: name, Message: err.Error()})
+			continue
+		}
+		config.markSet(key)
+		config.setSource(key, raw, "environment variable "+name)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// setOverride replaces the value of a configuration field with one given as
+// a string. A list is replaced by the comma-separated items of raw.
+func setOverride(field reflect.Value, raw string) error {
+	if field.Kind() != reflect.Slice {
+		return setFieldValue(field, raw)
+	}
+	field.Set(reflect.MakeSlice(field.Type(), 0, 0))
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			if err := setFieldValue(field, item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
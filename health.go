@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// healthState is what llauncher knows about a llama-server's health.
+type healthState string
+
+const (
+	// healthStarting: the server has been started, but does not answer yet.
+	healthStarting healthState = "starting"
+	// healthLoading: the server answers, but is still loading its model.
+	healthLoading healthState = "loading"
+	// healthReady: the server has loaded its model and serves requests.
+	healthReady healthState = "ready"
+	// healthUnhealthy: the server was ready, but its health checks fail.
+	healthUnhealthy healthState = "unhealthy"
+)
+
+// Defaults of the health checks.
+const (
+	defaultHealthInterval         = 5 * time.Second
+	defaultHealthTimeout          = 5 * time.Second
+	defaultHealthFailureThreshold = 3
+)
+
+// HealthConfig configures polling llama-server's /health endpoint, to tell
+// when it has loaded its model and to replace it when it stops answering.
+type HealthConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval is the time between checks, and Timeout the time each check
+	// may take.
+	Interval string `yaml:"interval"`
+	Timeout  string `yaml:"timeout"`
+	// StartupTimeout limits the time llama-server may take to become ready.
+	// It is unlimited if empty.
+	StartupTimeout string `yaml:"startup-timeout"`
+	// FailureThreshold is the number of failed checks in a row after which
+	// a server that was ready is killed.
+	FailureThreshold int `yaml:"failure-threshold"`
+}
+
+// healthCheck checks the health of one llama-server instance.
+type healthCheck struct {
+	url              string
+	apiKey           string
+	client           *http.Client
+	interval         time.Duration
+	startupTimeout   time.Duration
+	failureThreshold int
+}
+
+// newHealthCheck creates a health check for a validated configuration,
+// reaching llama-server the way the routing proxy does.
+func newHealthCheck(config *LlamaConfig) *healthCheck {
+	h := &healthCheck{
+		url:              upstreamURL(config).JoinPath("/health").String(),
+		apiKey:           upstreamAPIKey(config),
+		interval:         parseDurationOr(config.Health.Interval, defaultHealthInterval),
+		startupTimeout:   parseDurationOr(config.Health.StartupTimeout, 0),
+		failureThreshold: config.Health.FailureThreshold,
+		client: &http.Client{
+			Transport: upstreamTransport(),
+			Timeout:   parseDurationOr(config.Health.Timeout, defaultHealthTimeout),
+		},
+	}
+	if h.failureThreshold == 0 {
+		h.failureThreshold = defaultHealthFailureThreshold
+	}
+	return h
+}
+
+// upstreamAPIKey returns an API key llama-server accepts, if it requires one:
+// the first of its --api-key list, or else the first line of its
+// --api-key-file.
+func upstreamAPIKey(config *LlamaConfig) string {
+	if config.ApiKey != "" {
+		key, _, _ := strings.Cut(config.ApiKey, ",")
+		return strings.TrimSpace(key)
+	}
+	if config.ApiKeyFile != "" {
+		data, err := os.ReadFile(config.ApiKeyFile)
+		if err != nil {
+			return ""
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				return line
+			}
+		}
+	}
+	return ""
+}
+
+// probe checks the server's health once. A server that does not answer is
+// starting; one that answers 503 is loading its model.
+func (h *healthCheck) probe(ctx context.Context) (healthState, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return healthStarting, err
+	}
+	if h.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.apiKey)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return healthStarting, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return healthReady, nil
+	case http.StatusServiceUnavailable:
+		return healthLoading, fmt.Errorf("/health returned %s", resp.Status)
+	}
+	return healthUnhealthy, fmt.Errorf("/health returned %s", resp.Status)
+}
+
+// monitor checks the health of a child's current process until it exits,
+// and kills its process group if it does not become ready in time or stops
+// being healthy, so that the restart policy applies.
+func (s *supervisor) monitor(c *child, exited <-chan struct{}) {
+	h := c.health
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	var startupTimeout <-chan time.Time
+	if h.startupTimeout > 0 {
+		timer := time.NewTimer(h.startupTimeout)
+		defer timer.Stop()
+		startupTimeout = timer.C
+	}
+
+	ready := false
+	failures := 0
+	for {
+		select {
+		case <-exited:
+			return
+		case <-startupTimeout:
+			s.logger(c).Error("llama-server did not become ready in time, killing it", "event", "health", "pid", c.pid(), "signal", "SIGKILL", "timeout", h.startupTimeout.String())
+			c.signal(syscall.SIGKILL)
+			return
+		case <-ticker.C:
+		}
+
+		state, err := h.probe(context.Background())
+		switch {
+		case state == healthReady:
+			ready, failures = true, 0
+			startupTimeout = nil
+		case ready:
+			state = healthUnhealthy
+			failures++
+			if failures >= h.failureThreshold {
+				s.logger(c).Error("llama-server failed its health checks, killing it", "event", "health", "pid", c.pid(), "signal", "SIGKILL", "failures", failures, "error", err)
+				c.setState(state)
+				c.signal(syscall.SIGKILL)
+				return
+			}
+		case state == healthUnhealthy:
+			// An error before the model is loaded is part of loading.
+			state = healthLoading
+		}
+		if previous := c.setState(state); previous != state {
+			s.logState(c, state, err)
+		}
+	}
+}
+
+// logState reports a change of a child's health state.
+func (s *supervisor) logState(c *child, state healthState, err error) {
+	log := s.logger(c).With("event", "health", "pid", c.pid(), "state", string(state))
+	switch state {
+	case healthLoading:
+		log.Info("llama-server is loading the model")
+	case healthReady:
+		log.Info("llama-server is ready")
+	case healthUnhealthy:
+		log.Warn("llama-server is unhealthy", "error", err)
+	}
+}
+
+// validateHealth checks the health check settings of a configuration.
+func validateHealth(config HealthConfig) configErrors {
+	var errs configErrors
+	for _, d := range []struct{ key, value string }{
+		{"health.interval", config.Interval},
+		{"health.timeout", config.Timeout},
+		{"health.startup-timeout", config.StartupTimeout},
+	} {
+		errs = append(errs, validateDuration(d.key, d.value)...)
+	}
+	if d, err := time.ParseDuration(config.Interval); err == nil && d == 0 {
+		errs = append(errs, configError{Key: "health.interval", Message: "must be greater than zero"})
+	}
+	if config.FailureThreshold < 0 {
+		errs = append(errs, configError{
+			Key:     "health.failure-threshold",
+			Message: fmt.Sprintf("invalid value %d, must not be negative", config.FailureThreshold),
+		})
+	}
+	return errs
+}
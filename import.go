@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// argAliases maps the short and alternative forms of llama-server options to
+// the long form used in the `arg` tags of LlamaConfig.
+var argAliases = map[string]string{
+	"-m":                 "--model",
+	"-mu":                "--model-url",
+	"-hf":                "--hf-repo",
+	"-hfr":               "--hf-repo",
+	"-hfd":               "--hf-repo-draft",
+	"-hfrd":              "--hf-repo-draft",
+	"-hfv":               "--hf-repo-v",
+	"-hfrv":              "--hf-repo-v",
+	"-hff":               "--hf-file",
+	"-hffv":              "--hf-file-v",
+	"-hft":               "--hf-token",
+	"-a":                 "--alias",
+	"-to":                "--timeout",
+	"-t":                 "--threads",
+	"-tb":                "--threads-batch",
+	"-C":                 "--cpu-mask",
+	"-Cb":                "--cpu-mask-batch",
+	"-Cr":                "--cpu-range",
+	"-Crb":               "--cpu-range-batch",
+	"-b":                 "--batch-size",
+	"-ub":                "--ubatch-size",
+	"-ngl":               "--n-gpu-layers",
+	"--gpu-layers":       "--n-gpu-layers",
+	"-sm":                "--split-mode",
+	"-ts":                "--tensor-split",
+	"-mg":                "--main-gpu",
+	"-dev":               "--device",
+	"-np":                "--parallel",
+	"-c":                 "--ctx-size",
+	"-fa":                "--flash-attn",
+	"-ctk":               "--cache-type-k",
+	"-ctv":               "--cache-type-v",
+	"-ctkd":              "--cache-type-k-draft",
+	"-ctvd":              "--cache-type-v-draft",
+	"-kvu":               "--kv-unified",
+	"-nkvo":              "--no-kv-offload",
+	"-nr":                "--no-repack",
+	"-cmoe":              "--cpu-moe",
+	"-ncmoe":             "--n-cpu-moe",
+	"-ot":                "--override-tensor",
+	"-otd":               "--override-tensor-draft",
+	"-s":                 "--seed",
+	"--sampling-seq":     "--sampler-seq",
+	"-j":                 "--json-schema",
+	"-jf":                "--json-schema-file",
+	"-e":                 "--escape",
+	"-mm":                "--mmproj",
+	"-mmu":               "--mmproj-url",
+	"-cb":                "--cont-batching",
+	"-nocb":              "--no-cont-batching",
+	"-sps":               "--slot-prompt-similarity",
+	"--embedding":        "--embeddings",
+	"--rerank":           "--reranking",
+	"-l":                 "--logit-bias",
+	"-mv":                "--model-vocoder",
+	"-v":                 "--log-verbose",
+	"--verbose":          "--log-verbose",
+	"-lv":                "--log-verbosity",
+	"--verbosity":        "--log-verbosity",
+	"-n":                 "--n-predict",
+	"--predict":          "--n-predict",
+	"-r":                 "--reverse-prompt",
+	"-sp":                "--special",
+	"-md":                "--model-draft",
+	"-td":                "--threads-draft",
+	"-tbd":               "--threads-batch-draft",
+	"-cd":                "--ctx-size-draft",
+	"-devd":              "--device-draft",
+	"-ngld":              "--n-gpu-layers-draft",
+	"--gpu-layers-draft": "--n-gpu-layers-draft",
+	"--draft":            "--draft-max",
+	"--draft-n":          "--draft-max",
+	"--draft-n-min":      "--draft-min",
+}
+
+// importFlag describes how a llama-server option maps onto a LlamaConfig field.
+type importFlag struct {
+	key   string
+	index []int
+	// negated is true for the `neg` flag of a bool field, which sets it false.
+	negated bool
+}
+
+// importFlags maps every long-form llama-server option known to LlamaConfig,
+// including negated bool flags, to its field.
+func importFlags() map[string]importFlag {
+	flags := make(map[string]importFlag)
+	typ := reflect.TypeOf(LlamaConfig{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		argTag := field.Tag.Get("arg")
+		if argTag == "" {
+			continue
+		}
+		key := field.Tag.Get("yaml")
+		flags[argTag] = importFlag{key: key, index: field.Index}
+		if negTag := field.Tag.Get("neg"); negTag != "" {
+			if _, exists := flags[negTag]; !exists {
+				flags[negTag] = importFlag{key: key, index: field.Index, negated: true}
+			}
+		}
+	}
+	return flags
+}
+
+// importArgs parses a llama-server command line into a LlamaConfig. Options
+// that cannot be mapped onto a field, or whose values do not parse as the
+// field's type, are collected in the ExtraArgs of the result. A leading
+// program name (e.g. "llama-server" or "/app/llama-server") is skipped.
+func importArgs(argv []string) *LlamaConfig {
+	config := &LlamaConfig{}
+	val := reflect.ValueOf(config).Elem()
+	known := importFlags()
+
+	if len(argv) > 0 && !strings.HasPrefix(argv[0], "-") {
+		argv = argv[1:]
+	}
+
+	for i := 0; i < len(argv); i++ {
+		arg := argv[i]
+		name, inlineValue, hasInline := strings.Cut(arg, "=")
+		if !strings.HasPrefix(arg, "-") || !strings.HasPrefix(name, "-") {
+			config.ExtraArgs = append(config.ExtraArgs, arg)
+			continue
+		}
+		if canonical, ok := argAliases[name]; ok {
+			name = canonical
+		}
+
+		// takeValue returns the option's value, either from "--name=value"
+		// or from the next argument.
+		takeValue := func() (string, bool) {
+			if hasInline {
+				return inlineValue, true
+			}
+			if i+1 < len(argv) && isOptionValue(argv[i+1]) {
+				i++
+				return argv[i], true
+			}
+			return "", false
+		}
+
+		flagInfo, ok := known[name]
+		if !ok {
+			// Keep unknown options verbatim, with their value if they appear
+			// to have one.
+			config.ExtraArgs = append(config.ExtraArgs, arg)
+			if !hasInline {
+				if value, ok := takeValue(); ok {
+					config.ExtraArgs = append(config.ExtraArgs, value)
+				}
+			}
+			continue
+		}
+
+		field := val.FieldByIndex(flagInfo.index)
+		if field.Kind() == reflect.Bool {
+			enabled := !flagInfo.negated
+			// Some boolean options accept an explicit on/off value.
+			if value, ok := peekBoolValue(argv, i, inlineValue, hasInline); ok {
+				if !hasInline {
+					i++
+				}
+				enabled = enabled == value
+			} else if hasInline {
+				config.ExtraArgs = append(config.ExtraArgs, arg)
+				continue
+			}
+			field.SetBool(enabled)
+			config.markSet(flagInfo.key)
+			continue
+		}
+
+		if _, ok := field.Interface().(OnOffAuto); ok {
+			// The value is optional, as older llama-server versions took
+			// the option as a plain flag, which turned it on.
+			mode := OnOffAuto("on")
+			if value, ok := peekModeValue(argv, i, inlineValue, hasInline); ok {
+				if !hasInline {
+					i++
+				}
+				mode = value
+			} else if hasInline {
+				config.ExtraArgs = append(config.ExtraArgs, arg)
+				continue
+			}
+			field.Set(reflect.ValueOf(mode))
+			config.markSet(flagInfo.key)
+			continue
+		}
+
+		value, ok := takeValue()
+		if !ok {
+			config.ExtraArgs = append(config.ExtraArgs, arg)
+			continue
+		}
+		if err := setFieldValue(field, value); err != nil {
+			config.ExtraArgs = append(config.ExtraArgs, arg, value)
+			continue
+		}
+		config.markSet(flagInfo.key)
+	}
+
+	return config
+}
+
+// peekBoolValue looks for an explicit on/off style value for the boolean
+// option at argv[i], either inline ("--flag=on") or as the next argument.
+func peekBoolValue(argv []string, i int, inlineValue string, hasInline bool) (bool, bool) {
+	value := inlineValue
+	if !hasInline {
+		if i+1 >= len(argv) {
+			return false, false
+		}
+		value = argv[i+1]
+	}
+	switch strings.ToLower(value) {
+	case "on", "true", "enabled", "1":
+		return true, true
+	case "off", "false", "disabled", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// peekModeValue looks for an on, off or auto value, or a boolean, for the
+// option at argv[i], either inline ("--flag=auto") or as the next argument.
+func peekModeValue(argv []string, i int, inlineValue string, hasInline bool) (OnOffAuto, bool) {
+	value := inlineValue
+	if !hasInline {
+		if i+1 >= len(argv) {
+			return "", false
+		}
+		value = argv[i+1]
+	}
+	var mode OnOffAuto
+	mode.UnmarshalText([]byte(value))
+	switch mode {
+	case "on", "off", "auto":
+		return mode, true
+	}
+	return "", false
+}
+
+// isOptionValue reports whether an argument following an option is its value
+// rather than the next option. Negative numbers count as values.
+func isOptionValue(arg string) bool {
+	if !strings.HasPrefix(arg, "-") {
+		return true
+	}
+	_, err := strconv.ParseFloat(arg, 64)
+	return err == nil
+}
+
+// marshalConfig renders the keys that are set in a configuration as YAML, in
+// the order of the LlamaConfig fields, with extra-args last.
+func marshalConfig(config *LlamaConfig) ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	val := reflect.ValueOf(config).Elem()
+	typ := val.Type()
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		key := field.Tag.Get("yaml")
+		if field.Tag.Get("arg") == "" || !config.isSet(key) {
+			continue
+		}
+		var value yaml.Node
+		if err := value.Encode(val.Field(i).Interface()); err != nil {
+			return nil, err
+		}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &value)
+	}
+
+	if len(config.ExtraArgs) > 0 {
+		var value yaml.Node
+		if err := value.Encode([]string(config.ExtraArgs)); err != nil {
+			return nil, err
+		}
+		root.Content = append(root.Content, &yaml.Node{
+			Kind:        yaml.ScalarNode,
+			Value:       "extra-args",
+			HeadComment: "Options that could not be mapped to llauncher keys, passed verbatim",
+		}, &value)
+	}
+
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// runImport implements the "import" subcommand. It converts an existing
+// llama-server command line into a llauncher YAML configuration, written to
+// stdout or to a new file.
+func runImport(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("llauncher import", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	output := flags.String("o", "", "Write the configuration to this file instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage:")
+		fmt.Fprintln(stderr, "  llauncher import [-o <file>] [--] llama-server <llama-server args>...")
+		fmt.Fprintln(stderr, "\nOptions:")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	config := importArgs(flags.Args())
+	out, err := marshalConfig(config)
+	if err != nil {
+		fmt.Fprintf(stderr, "llauncher: failed to write configuration: %v\n", err)
+		return 1
+	}
+
+	if len(config.ExtraArgs) > 0 {
+		fmt.Fprintf(stderr, "llauncher: could not map %s, kept in extra-args\n", strings.Join(config.ExtraArgs, " "))
+	}
+
+	if *output == "" {
+		stdout.Write(out)
+		return 0
+	}
+
+	// Refuse to overwrite an existing configuration.
+	file, err := os.OpenFile(filepath.Clean(*output), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		fmt.Fprintf(stderr, "llauncher: %v\n", err)
+		return 1
+	}
+	if _, err := file.Write(out); err != nil {
+		file.Close()
+		fmt.Fprintf(stderr, "llauncher: %v\n", err)
+		return 1
+	}
+	if err := file.Close(); err != nil {
+		fmt.Fprintf(stderr, "llauncher: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -1,20 +1,23 @@
 package main
 
 import (
+	"encoding"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
-	"os/signal"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
-	"syscall"
 	"unicode"
 
 	"gopkg.in/yaml.v3"
 )
 
-
 // execCommand is a wrapper around exec.Command that can be swapped out in tests.
 // By default it points at the real exec.Command implementation.
 var execCommand = exec.Command
@@ -23,13 +26,15 @@ var execCommand = exec.Command
 // The `yaml` struct tags map YAML keys to the struct fields.
 // The `arg` struct tags define the corresponding command-line flag, which is
 // now the single source of truth for argument generation.
+// The optional `neg` struct tag on a bool field names the flag to pass when
+// the key is explicitly set to false in the YAML.
 type LlamaConfig struct {
 	// Basic server configuration
 	Host        string `yaml:"host" arg:"--host"`
 	Port        int    `yaml:"port" arg:"--port"`
 	Path        string `yaml:"path" arg:"--path"`
 	ApiPrefix   string `yaml:"api-prefix" arg:"--api-prefix"`
-	NoWebUI     bool   `yaml:"no-webui" arg:"--no-webui"`
+	NoWebUI     bool   `yaml:"no-webui" arg:"--no-webui" neg:"--webui"`
 	Timeout     int    `yaml:"timeout" arg:"--timeout"`
 	ThreadsHTTP int    `yaml:"threads-http" arg:"--threads-http"`
 	Props       bool   `yaml:"props" arg:"--props"`
@@ -67,24 +72,24 @@ type LlamaConfig struct {
 	MainGPU        int    `yaml:"main-gpu" arg:"--main-gpu"`
 	Numa           string `yaml:"numa" arg:"--numa"`
 	Device         string `yaml:"device" arg:"--device"`
-	NoPerf         bool   `yaml:"no-perf" arg:"--no-perf"`
+	NoPerf         bool   `yaml:"no-perf" arg:"--no-perf" neg:"--perf"`
 	Parallel       int    `yaml:"parallel" arg:"--parallel"`
 
 	// Memory management
-	ContextSize     int    `yaml:"ctx-size" arg:"--ctx-size"`
-	FlashAttn       bool   `yaml:"flash-attn" arg:"--flash-attn"`
-	Mlock           bool   `yaml:"mlock" arg:"--mlock"`
-	NoMMap          bool   `yaml:"no-mmap" arg:"--no-mmap"`
-	CacheTypeK      string `yaml:"cache-type-k" arg:"--cache-type-k"`
-	CacheTypeKDraft string `yaml:"cache-type-k-draft" arg:"--cache-type-k-draft"`
-	CacheTypeV      string `yaml:"cache-type-v" arg:"--cache-type-v"`
-	CacheTypeVDraft string `yaml:"cache-type-v-draft" arg:"--cache-type-v-draft"`
-	CacheReuse      int    `yaml:"cache-reuse" arg:"--cache-reuse"`
-	SwaFull         bool   `yaml:"swa-full" arg:"--swa-full"`
-	KvUnified       bool   `yaml:"kv-unified" arg:"--kv-unified"`
-	NoKvOffload     bool   `yaml:"no-kv-offload" arg:"--no-kv-offload"`
-	NoRepack        bool   `yaml:"no-repack" arg:"--no-repack"`
-	NoOpOffload     bool   `yaml:"no-op-offload" arg:"--no-op-offload"`
+	ContextSize     int       `yaml:"ctx-size" arg:"--ctx-size"`
+	FlashAttn       OnOffAuto `yaml:"flash-attn" arg:"--flash-attn"`
+	Mlock           bool      `yaml:"mlock" arg:"--mlock"`
+	NoMMap          bool      `yaml:"no-mmap" arg:"--no-mmap" neg:"--mmap"`
+	CacheTypeK      string    `yaml:"cache-type-k" arg:"--cache-type-k"`
+	CacheTypeKDraft string    `yaml:"cache-type-k-draft" arg:"--cache-type-k-draft"`
+	CacheTypeV      string    `yaml:"cache-type-v" arg:"--cache-type-v"`
+	CacheTypeVDraft string    `yaml:"cache-type-v-draft" arg:"--cache-type-v-draft"`
+	CacheReuse      int       `yaml:"cache-reuse" arg:"--cache-reuse"`
+	SwaFull         bool      `yaml:"swa-full" arg:"--swa-full"`
+	KvUnified       bool      `yaml:"kv-unified" arg:"--kv-unified"`
+	NoKvOffload     bool      `yaml:"no-kv-offload" arg:"--no-kv-offload" neg:"--kv-offload"`
+	NoRepack        bool      `yaml:"no-repack" arg:"--no-repack" neg:"--repack"`
+	NoOpOffload     bool      `yaml:"no-op-offload" arg:"--no-op-offload" neg:"--op-offload"`
 
 	// RoPE configuration
 	RopeScaling   string  `yaml:"rope-scaling" arg:"--rope-scaling"`
@@ -139,8 +144,8 @@ type LlamaConfig struct {
 	GrammarFile    string `yaml:"grammar-file" arg:"--grammar-file"`
 	JsonSchema     string `yaml:"json-schema" arg:"--json-schema"`
 	JsonSchemaFile string `yaml:"json-schema-file" arg:"--json-schema-file"`
-	Escape         bool   `yaml:"escape" arg:"--escape"`
-	NoEscape       bool   `yaml:"no-escape" arg:"--no-escape"`
+	Escape         bool   `yaml:"escape" arg:"--escape" neg:"--no-escape"`
+	NoEscape       bool   `yaml:"no-escape" arg:"--no-escape" neg:"--escape"`
 	SpmInfill      bool   `yaml:"spm-infill" arg:"--spm-infill"`
 
 	// Adapters and extensions
@@ -153,14 +158,14 @@ type LlamaConfig struct {
 	MmProj                  string   `yaml:"mmproj" arg:"--mmproj"`
 	MmProjUrl               string   `yaml:"mmproj-url" arg:"--mmproj-url"`
 	NoMmProj                bool     `yaml:"no-mmproj" arg:"--no-mmproj"`
-	NoMmProjOffload         bool     `yaml:"no-mmproj-offload" arg:"--no-mmproj-offload"`
+	NoMmProjOffload         bool     `yaml:"no-mmproj-offload" arg:"--no-mmproj-offload" neg:"--mmproj-offload"`
 
 	// Server features
-	ContBatching         bool    `yaml:"cont-batching" arg:"--cont-batching"`
-	NoContBatching       bool    `yaml:"no-cont-batching" arg:"--no-cont-batching"`
+	ContBatching         bool    `yaml:"cont-batching" arg:"--cont-batching" neg:"--no-cont-batching"`
+	NoContBatching       bool    `yaml:"no-cont-batching" arg:"--no-cont-batching" neg:"--cont-batching"`
 	Metrics              bool    `yaml:"metrics" arg:"--metrics"`
-	Slots                bool    `yaml:"slots" arg:"--slots"`
-	NoSlots              bool    `yaml:"no-slots" arg:"--no-slots"`
+	Slots                bool    `yaml:"slots" arg:"--slots" neg:"--no-slots"`
+	NoSlots              bool    `yaml:"no-slots" arg:"--no-slots" neg:"--slots"`
 	SlotSavePath         string  `yaml:"slot-save-path" arg:"--slot-save-path"`
 	SlotPromptSimilarity float64 `yaml:"slot-prompt-similarity" arg:"--slot-prompt-similarity"`
 	SwaCheckpoints       int     `yaml:"swa-checkpoints" arg:"--swa-checkpoints"`
@@ -175,8 +180,8 @@ type LlamaConfig struct {
 	ChatTemplate       string `yaml:"chat-template" arg:"--chat-template"`
 	ChatTemplateFile   string `yaml:"chat-template-file" arg:"--chat-template-file"`
 	ChatTemplateKwargs string `yaml:"chat-template-kwargs" arg:"--chat-template-kwargs"`
-	Jinja              bool   `yaml:"jinja" arg:"--jinja"`
-	NoPrefillAssistant bool   `yaml:"no-prefill-assistant" arg:"--no-prefill-assistant"`
+	Jinja              bool   `yaml:"jinja" arg:"--jinja" neg:"--no-jinja"`
+	NoPrefillAssistant bool   `yaml:"no-prefill-assistant" arg:"--no-prefill-assistant" neg:"--prefill-assistant"`
 	ReasoningFormat    string `yaml:"reasoning-format" arg:"--reasoning-format"`
 	ReasoningBudget    int    `yaml:"reasoning-budget" arg:"--reasoning-budget"`
 
@@ -202,9 +207,9 @@ type LlamaConfig struct {
 	Predict        int    `yaml:"n-predict" arg:"--n-predict"`
 	ReversePrompt  string `yaml:"reverse-prompt" arg:"--reverse-prompt"`
 	Special        bool   `yaml:"special" arg:"--special"`
-	NoWarmup       bool   `yaml:"no-warmup" arg:"--no-warmup"`
-	NoContextShift bool   `yaml:"no-context-shift" arg:"--no-context-shift"`
-	ContextShift   bool   `yaml:"context-shift" arg:"--context-shift"`
+	NoWarmup       bool   `yaml:"no-warmup" arg:"--no-warmup" neg:"--warmup"`
+	NoContextShift bool   `yaml:"no-context-shift" arg:"--no-context-shift" neg:"--context-shift"`
+	ContextShift   bool   `yaml:"context-shift" arg:"--context-shift" neg:"--no-context-shift"`
 	Keep           int    `yaml:"keep" arg:"--keep"`
 
 	// Speculative decoding
@@ -218,113 +223,596 @@ type LlamaConfig struct {
 	DraftMin          int     `yaml:"draft-min" arg:"--draft-min"`
 	DraftPMin         float64 `yaml:"draft-p-min" arg:"--draft-p-min"`
 	SpecReplace       string  `yaml:"spec-replace" arg:"--spec-replace"`
+
+	// Options not listed above, passed verbatim after all other arguments
+	ExtraArgs ExtraArgs `yaml:"extra-args"`
+
+	// Launcher settings, which have no `arg` tag and are not passed to
+	// llama-server
+
+	// Several llama-server instances to run instead of one. Each entry
+	// inherits the options set at the top level.
+	Models     []LlamaConfig `yaml:"models"`
+	ExitPolicy string        `yaml:"exit-policy"`
+	Proxy      ProxyConfig   `yaml:"proxy"`
+	Status     StatusConfig  `yaml:"status"`
+	Output     OutputConfig  `yaml:"output"`
+
+	// Restarting llama-server when it exits, which each model may set
+	// for itself.
+	Restart RestartConfig `yaml:"restart"`
+	Health  HealthConfig  `yaml:"health"`
+	// How llama-server is stopped: the signal sent in place of SIGTERM,
+	// and how long it may take before it is killed, e.g. "30s".
+	StopSignal      string `yaml:"stop-signal"`
+	ShutdownTimeout string `yaml:"shutdown-timeout"`
+
+	// set records the YAML keys that were explicitly present in the
+	// configuration, so that zero values written by the user (e.g.
+	// `n-gpu-layers: 0`) can be told apart from keys that were left out.
+	set map[string]bool
+
+	// expansions records the values that were expanded from environment
+	// variables, for debug output.
+	expansions []envExpansion
+
+	// sources records the file and line each final value came from, for
+	// debug output.
+	sources []configSource
+
+	// profiles lists the names of the profiles defined in the configuration.
+	profiles []string
+
+	// inModels is true for the entries of a models list.
+	inModels bool
+}
+
+// UnmarshalYAML decodes the configuration and records which keys were present
+// in the YAML mapping. Keys with a null value are treated as unset.
+func (c *LlamaConfig) UnmarshalYAML(value *yaml.Node) error {
+	// plain has the same fields as LlamaConfig but no UnmarshalYAML method,
+	// which avoids infinite recursion.
+	type plain LlamaConfig
+	if err := value.Decode((*plain)(c)); err != nil {
+		return err
+	}
+
+	if value.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			if value.Content[i+1].ShortTag() == "!!null" {
+				continue
+			}
+			c.markSet(value.Content[i].Value)
+		}
+	}
+	return nil
+}
+
+// markSet records that the given YAML key was explicitly set.
+func (c *LlamaConfig) markSet(key string) {
+	if c.set == nil {
+		c.set = make(map[string]bool)
+	}
+	c.set[key] = true
+}
+
+// isSet reports whether the given YAML key was explicitly set.
+func (c *LlamaConfig) isSet(key string) bool {
+	return c.set[key]
+}
+
+// ExtraArgs holds llama-server arguments that have no LlamaConfig field, so that
+// new llama-server options can be used before llauncher knows about them.
+// In the YAML it is either a list of raw arguments, which are passed verbatim:
+//
+//	extra-args: ["--some-flag", "--some-option", "value"]
+//
+// or a mapping of option names (with or without the leading dashes) to
+// values, which are rendered according to their YAML type:
+//
+//	extra-args:
+//	  some-flag: true     # --some-flag
+//	  other-flag: false   # omitted
+//	  bare-flag:          # --bare-flag
+//	  some-option: 42     # --some-option 42
+//	  repeated: [a, b]    # --repeated a --repeated b
+type ExtraArgs []string
+
+// UnmarshalYAML renders the YAML list or mapping into raw arguments.
+func (e *ExtraArgs) UnmarshalYAML(value *yaml.Node) error {
+	var args []string
+	switch value.Kind {
+	case yaml.SequenceNode:
+		for _, item := range value.Content {
+			if item.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: extra-args list items must be scalars", item.Line)
+			}
+			args = append(args, item.Value)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			key, val := value.Content[i], value.Content[i+1]
+			flag := key.Value
+			if !strings.HasPrefix(flag, "-") {
+				flag = "--" + flag
+			}
+			rendered, err := renderExtraArg(flag, val)
+			if err != nil {
+				return err
+			}
+			args = append(args, rendered...)
+		}
+	case yaml.ScalarNode:
+		if value.ShortTag() != "!!null" {
+			return fmt.Errorf("line %d: extra-args must be a list or a mapping", value.Line)
+		}
+	default:
+		return fmt.Errorf("line %d: extra-args must be a list or a mapping", value.Line)
+	}
+	*e = args
+	return nil
+}
+
+// OnOffAuto is the value of an option that takes on, off or auto, such as
+// flash-attn. A boolean is accepted for on or off, as older llama-server
+// versions took the option as a plain flag.
+type OnOffAuto string
+
+// UnmarshalYAML reads on, off, auto or a boolean.
+func (o *OnOffAuto) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: must be on, off or auto", value.Line)
+	}
+	if value.ShortTag() == "!!null" {
+		*o = ""
+		return nil
+	}
+	return o.UnmarshalText([]byte(value.Value))
+}
+
+// UnmarshalText reads on, off, auto or a boolean, e.g. from an environment
+// variable. Any other value is kept, for validation to report.
+func (o *OnOffAuto) UnmarshalText(text []byte) error {
+	value := strings.ToLower(string(text))
+	switch value {
+	case "on", "true", "yes", "enabled", "1":
+		value = "on"
+	case "off", "false", "no", "disabled", "0":
+		value = "off"
+	case "auto":
+	default:
+		value = string(text)
+	}
+	*o = OnOffAuto(value)
+	return nil
+}
+
+// renderExtraArg renders a single extra-args mapping entry as arguments.
+func renderExtraArg(flag string, value *yaml.Node) ([]string, error) {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		switch value.ShortTag() {
+		case "!!null":
+			return []string{flag}, nil
+		case "!!bool":
+			var b bool
+			if err := value.Decode(&b); err != nil {
+				return nil, err
+			}
+			if b {
+				return []string{flag}, nil
+			}
+			return nil, nil
+		}
+		rendered, err := renderExtraValue(value)
+		if err != nil {
+			return nil, err
+		}
+		return []string{flag, rendered}, nil
+	case yaml.SequenceNode:
+		var args []string
+		for _, item := range value.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: extra-args value for %s must be a scalar or a list of scalars", item.Line, flag)
+			}
+			rendered, err := renderExtraValue(item)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, flag, rendered)
+		}
+		return args, nil
+	default:
+		return nil, fmt.Errorf("line %d: extra-args value for %s must be a scalar or a list of scalars", value.Line, flag)
+	}
+}
+
+// renderExtraValue renders a scalar value in the same way as buildArgs renders
+// LlamaConfig fields of the same type.
+func renderExtraValue(value *yaml.Node) (string, error) {
+	switch value.ShortTag() {
+	case "!!int":
+		var i int64
+		if err := value.Decode(&i); err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(i, 10), nil
+	case "!!float":
+		var f float64
+		if err := value.Decode(&f); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%g", f), nil
+	default:
+		return value.Value, nil
+	}
 }
 
 // showHelp displays usage information for the launcher
 func showHelp() {
 	fmt.Println("llauncher - A launcher for llama-server")
 	fmt.Println("\nUsage:")
-	fmt.Println("  llauncher [--config <config_file>] [--help] [--debug]")
+	fmt.Println("  llauncher [--config <config_file>] [--profile <name>] [--set key=value] [--help] [--debug] [--log-level <level>] [--log-format text|json] [--no-strict] [--dry-run] [--init] [--reload] [--blue-green] [-- <llama-server args>...]")
+	fmt.Println("  llauncher validate [--format text|json] [--no-strict] [<file|directory>...]")
+	fmt.Println("  llauncher print-args [--config <config_file>] [--profile <name>] [--set key=value] [--format pretty|shell|json|compose|dockerfile] [--no-strict] [-- <llama-server args>...]")
+	fmt.Println("  llauncher import [-o <file>] [--] llama-server <llama-server args>...")
 	fmt.Println("\nOptions:")
-	fmt.Println("  --config <file>    Path to YAML configuration file")
+	fmt.Println("  --config <file>    Path to YAML configuration file; repeat to merge overlays in order")
+	fmt.Println("  --profile <name>   Use the named profile from the configuration")
+	fmt.Println("  --set key=value    Override a configuration key; key+=value appends to a list")
 	fmt.Println("  --help             Show this help message")
-	fmt.Println("  --debug            Print debug information including the full command")
+	fmt.Println("  --debug            Log debug messages, including the full command")
+	fmt.Println("  --log-level <lvl>  Level of llauncher's log: debug, info (default), warn or error")
+	fmt.Println("  --log-format <f>   Format of llauncher's log on stderr: text (default) or json")
+	fmt.Println("  --no-strict        Ignore unknown keys in the configuration file")
+	fmt.Println("  --dry-run          Print the llama-server command instead of running it")
+	fmt.Println("  --init             Reap orphaned processes like an init system (automatic as PID 1)")
+	fmt.Println("  --reload           Reload the configuration on SIGHUP or when a configuration file changes")
+	fmt.Println("  --blue-green       Reload without downtime, starting the new llama-server before stopping the old one")
+	fmt.Println("  -- <args>...       Pass the remaining arguments verbatim to llama-server")
+	fmt.Println("\nCommands:")
+	fmt.Println("  validate           Check configuration files without starting llama-server")
+	fmt.Println("  print-args         Print the llama-server command in various formats")
+	fmt.Println("  import             Convert a llama-server command line into a YAML configuration")
 	fmt.Println("\nEnvironment Variables:")
 	fmt.Println("  LLAMA_CONFIG_PATH  Path to YAML configuration file (overridden by --config)")
+	fmt.Println("  LLAUNCHER_PROFILE  Name of the profile to use (overridden by --profile)")
+	fmt.Println("  LLAUNCHER_<KEY>    Override a configuration key, e.g. LLAUNCHER_N_GPU_LAYERS=99")
 }
 
 func main() {
-	// Help flag
-	if isHelpRequested() {
-		showHelp()
-		return
+	os.Exit(run())
+}
+
+// run is the body of main. It returns the exit code for llauncher, which is
+// the exit status of llama-server once it has been started, so that
+// orchestrators see failures of the server as failures of the container.
+func run() int {
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			return runValidate(os.Args[2:], os.Stdout, os.Stderr)
+		case "print-args":
+			return runPrintArgs(os.Args[2:], os.Stdout, os.Stderr)
+		case "import":
+			return runImport(os.Args[2:], os.Stdout, os.Stderr)
+		}
 	}
 
-	// Debug flag
-	debug := isDebugMode()
+	opts, err := parseOptions(os.Args[1:], os.Stderr)
+	if err != nil {
+		// Help flag
+		if errors.Is(err, flag.ErrHelp) {
+			showHelp()
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "llauncher: %v\n", err)
+		return 2
+	}
 
-	// Resolve configuration file path (XDG‑compliant)
-	configFile := resolveConfigPath()
-	if debug {
-		fmt.Printf("Loading configuration from: %s\n", configFile)
+	// llauncher's own messages are logged to stderr, apart from
+	// llama-server's output
+	level := slog.LevelInfo
+	if opts.debug {
+		level = slog.LevelDebug
 	}
+	if opts.logLevel != "" {
+		level, _ = parseLogLevel(opts.logLevel)
+	}
+	slog.SetDefault(newLogger(os.Stderr, level, opts.logFormat))
 
-	// Load configuration
-	config, err := loadConfig(configFile)
+	// Resolve configuration file paths (XDG‑compliant)
+	configFiles, err := resolveConfigPaths(opts.configFiles)
 	if err != nil {
-		if debug {
-			fmt.Printf("Failed to load configuration: %v\n", err)
-		}
-		showHelp()
-		os.Exit(1)
+		slog.Error("Could not find the configuration", "event", "config", "error", err)
+		return 1
 	}
+	profile := resolveProfile(opts.profile)
+	slog.Debug("Loading configuration", "event", "config", "config_path", configFiles, "profile", profile)
 
-	// Build arguments for llama-server
-	args, err := buildArgs(config)
+	// Load and validate the configuration, and build arguments for llama-server
+	prepare := func() (*LlamaConfig, []server, error) {
+		return prepareServers(configFiles, loadOptions{Strict: !opts.noStrict, Profile: profile}, opts.sets, opts.passthrough)
+	}
+	config, servers, err := prepare()
 	if err != nil {
-		if debug {
-			fmt.Printf("Failed to build arguments: %v\n", err)
+		slog.Error("Could not load the configuration", "event", "config", "config_path", configFiles, "error", err)
+		var errs configErrors
+		if !errors.As(err, &errs) {
+			showHelp()
+		}
+		return 1
+	}
+
+	// Dry run: print the commands instead of running them
+	if opts.dryRun {
+		out, err := formatServers(servers, "pretty")
+		if err != nil {
+			slog.Error("Could not print the command", "event", "config", "error", err)
+			return 1
+		}
+		fmt.Print(out)
+		return 0
+	}
+
+	// Debug output of the full command, with secrets redacted
+	logConfigValues(config)
+	for _, srv := range servers {
+		log := slog.Default()
+		if srv.name != "" {
+			log = log.With("model", srv.name)
+		}
+		log.Debug("Full command that will be executed", "event", "command", "config_path", configFiles, "args", redactArgs(srv.args))
+	}
+
+	sup := newSupervisor(config, servers)
+
+	// Reload the configuration on SIGHUP and when its files change
+	if opts.blueGreen && len(servers) > 1 {
+		slog.Error("--blue-green can only be used with a single model", "event", "config")
+		return 1
+	}
+	if opts.reload || opts.blueGreen {
+		if config.Proxy.Swap {
+			slog.Error("--reload cannot be used with proxy.swap", "event", "config")
+			return 1
+		}
+		sup.reload = prepare
+		changes, stop, err := watchFiles(configFiles)
+		if err != nil {
+			slog.Warn("Reload with SIGHUP instead", "event", "reload", "config_path", configFiles, "error", err)
+		} else {
+			defer stop()
+			sup.changes = changes
+			slog.Debug("Watching the configuration for changes", "event", "reload", "config_path", configFiles)
 		}
-		os.Exit(1)
 	}
 
-	// Debug output of the full command
-	if debug {
-		fmt.Printf("DEBUG: Configuration file: %s\n", configFile)
-		fmt.Println("DEBUG: Full command that will be executed:")
-		fmt.Printf("DEBUG: llama-server %s\n", formatArgsForDisplay(args))
+	// Reap orphaned processes when llauncher is a container's init process
+	if opts.init || os.Getpid() == 1 {
+		stop, err := startReaper(sup)
+		if err != nil {
+			slog.Error("Could not reap orphaned processes", "event", "reap", "error", err)
+			return 1
+		}
+		defer stop()
+		slog.Debug("Reaping orphaned processes", "event", "reap", "pid", os.Getpid())
 	}
 
-	// Prepare the command
-	cmd := execCommand("llama-server", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// Report the servers' state to orchestrators, if configured
+	if config.Status.Listen != "" {
+		stop, err := startStatusServer(config.Status.Listen, sup)
+		if err != nil {
+			slog.Error("Could not start the status endpoint", "event", "listen", "error", err)
+			return 1
+		}
+		defer stop()
+		slog.Debug("Status endpoint listening", "event", "listen", "address", config.Status.Listen)
+	}
 
-	// Run the child in its own process group so we can forward signals to it (and any
-	// subprocesses it may spawn) with a single kill call.
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// In blue/green mode llauncher listens on the server's port itself
+	if opts.blueGreen {
+		return runBlueGreen(config, sup)
+	}
 
-	// Forward signals
-	startSignalForwarder(cmd, debug)
+	// In swap mode the proxy starts the servers on demand
+	if config.Proxy.Swap {
+		return runSwap(config, sup, servers)
+	}
+
+	// Give the servers a single endpoint, if configured
+	if config.Proxy.Listen != "" {
+		stop, err := startProxy(config.Proxy.Listen, newRoutingProxy(servers))
+		if err != nil {
+			slog.Error("Could not start the routing proxy", "event", "listen", "error", err)
+			return 1
+		}
+		defer stop()
+		slog.Debug("Routing proxy listening", "event", "listen", "address", config.Proxy.Listen)
+	}
 
-	// Run and exit with proper status
-	_ = runCommand(cmd, debug)
-	// Do not call os.Exit to allow tests to run without exiting.
+	// Run the servers, forwarding signals to them, and exit with proper status
+	return sup.run()
 }
 
-// loadConfig reads a YAML file and unmarshals it into a LlamaConfig struct.
-func loadConfig(path string) (*LlamaConfig, error) {
-	yamlFile, err := os.ReadFile(path)
+// prepareServers loads and merges the configuration files, applies
+// environment and --set overrides, validates the result and builds the full
+// llama-server argument list for each server from it, followed by any
+// passthrough arguments.
+func prepareServers(configFiles []string, opts loadOptions, sets []string, passthrough []string) (*LlamaConfig, []server, error) {
+	config, err := loadConfigFiles(configFiles, opts)
 	if err != nil {
-		return nil, fmt.Errorf("could not read yaml file: %w", err)
+		return nil, nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Overrides apply to each model too, which would otherwise keep the
+	// values they inherited from the files.
+	configs := []*LlamaConfig{config}
+	for i := range config.Models {
+		configs = append(configs, &config.Models[i])
+	}
+	for _, c := range configs {
+		// Environment variables override the values from the files
+		if err := applyEnvOverrides(c, os.LookupEnv); err != nil {
+			return nil, nil, fmt.Errorf("invalid environment overrides:\n%w", err)
+		}
+
+		// Command-line overrides take precedence over everything else
+		if err := applySetOverrides(c, sets); err != nil {
+			return nil, nil, fmt.Errorf("invalid --set overrides:\n%w", err)
+		}
+	}
+
+	// Validate the configuration before starting anything
+	if err := validateConfigFiles(config, !opts.NoFileChecks); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration in %s:\n%w", strings.Join(configFiles, ", "), err)
 	}
 
-	var config LlamaConfig
-	err = yaml.Unmarshal(yamlFile, &config)
+	servers, err := buildServers(config, passthrough)
 	if err != nil {
-		return nil, fmt.Errorf("could not unmarshal yaml: %w", err)
+		return nil, nil, fmt.Errorf("failed to build arguments: %w", err)
 	}
+	return config, servers, nil
+}
 
-	return &config, nil
+// logConfigValues logs, at debug level, each expanded variable and each final
+// configuration value with where it came from: a file and line, an
+// environment variable or --set. Secret values are redacted.
+func logConfigValues(config *LlamaConfig) {
+	for _, e := range config.expansions {
+		value := e.Value
+		if e.Secret {
+			value = "<redacted>"
+		}
+		slog.Debug("Expanded variable", "event", "config", "key", e.Key, "template", e.Template, "value", value)
+	}
+	for _, src := range config.sources {
+		value := src.Value
+		if src.Secret {
+			value = "<redacted>"
+		}
+		slog.Debug("Configuration value", "event", "config", "key", src.Key, "value", value, "origin", src.Origin)
+	}
 }
 
-// isHelpRequested returns true when the first argument is "--help".
-func isHelpRequested() bool {
-	return len(os.Args) > 1 && os.Args[1] == "--help"
+// loadOptions controls how configuration files are loaded.
+type loadOptions struct {
+	// Strict rejects keys that are not known configuration options, instead
+	// of silently ignoring them.
+	Strict bool
+	// Profile names the profile to merge over the shared configuration, if
+	// any.
+	Profile string
+	// NoFileChecks skips checking that the local files the configuration
+	// names exist, e.g. to print a command for another machine.
+	NoFileChecks bool
 }
 
-// isDebugMode returns true when any argument equals "--debug".
-func isDebugMode() bool {
-	for _, a := range os.Args {
-		if a == "--debug" {
-			return true
+// loadConfig reads a YAML file and unmarshals it into a LlamaConfig struct,
+// rejecting unknown keys.
+func loadConfig(path string) (*LlamaConfig, error) {
+	return loadConfigWithOptions(path, loadOptions{Strict: true})
+}
+
+// loadConfigWithOptions reads a YAML file and unmarshals it into a LlamaConfig
+// struct. In strict mode every unknown key is reported as a configErrors value.
+func loadConfigWithOptions(path string, opts loadOptions) (*LlamaConfig, error) {
+	return loadConfigFiles([]string{path}, opts)
+}
+
+// options holds llauncher's own command-line options.
+type options struct {
+	configFiles []string
+	profile     string
+	debug       bool
+	noStrict    bool
+	dryRun      bool
+	init        bool
+	reload      bool
+	blueGreen   bool
+	sets        []string
+	// logLevel and logFormat configure llauncher's own log. An empty
+	// level is debug with --debug, and info otherwise; an empty format is
+	// text.
+	logLevel  string
+	logFormat string
+	// passthrough holds the arguments after "--", which are passed
+	// verbatim to llama-server.
+	passthrough []string
+}
+
+// parseOptions parses llauncher's own command-line arguments, not including
+// the program name. Parse errors are written to output; --help is reported
+// as flag.ErrHelp.
+func parseOptions(args []string, output io.Writer) (*options, error) {
+	var opts options
+	flags := flag.NewFlagSet("llauncher", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.Usage = func() {}
+	flags.Var((*stringList)(&opts.configFiles), "config", "Path to YAML configuration file; repeat to merge overlays in order")
+	flags.StringVar(&opts.profile, "profile", "", "Name of the profile to use from the configuration")
+	flags.BoolVar(&opts.debug, "debug", false, "Log debug messages, including the full command")
+	flags.StringVar(&opts.logLevel, "log-level", "", "Level of llauncher's log: debug, info, warn or error")
+	flags.StringVar(&opts.logFormat, "log-format", "", "Format of llauncher's log: text or json")
+	flags.BoolVar(&opts.noStrict, "no-strict", false, "Ignore unknown keys in the configuration file")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "Print the llama-server command instead of running it")
+	flags.BoolVar(&opts.init, "init", false, "Reap orphaned processes like an init system")
+	flags.BoolVar(&opts.reload, "reload", false, "Reload the configuration on SIGHUP or when a configuration file changes")
+	flags.BoolVar(&opts.blueGreen, "blue-green", false, "Reload without downtime, starting the new llama-server before stopping the old one")
+	flags.Var((*stringList)(&opts.sets), "set", "Override a configuration key, as key=value or key+=value for lists")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if opts.logLevel != "" {
+		if _, err := parseLogLevel(opts.logLevel); err != nil {
+			return nil, err
 		}
 	}
-	return false
+	if opts.logFormat != "" && !slices.Contains(logFormats, opts.logFormat) {
+		return nil, fmt.Errorf("invalid --log-format %q, must be one of: %s", opts.logFormat, strings.Join(logFormats, ", "))
+	}
+
+	passthrough, err := passthroughArgs(args, flags.Args())
+	if err != nil {
+		return nil, err
+	}
+	opts.passthrough = passthrough
+	return &opts, nil
+}
+
+// passthroughArgs returns the arguments for llama-server left after parsing
+// args as flags. The flag package stops at "--", which it consumes, or at the
+// first argument that is not a flag. Only the former starts the arguments
+// for llama-server.
+func passthroughArgs(args, rest []string) ([]string, error) {
+	if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+		return rest, nil
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("unexpected argument %q; use -- to pass arguments to llama-server", rest[0])
+	}
+	return nil, nil
+}
+
+// resolveConfigPaths determines the configuration files to load. Each --config
+// flag names a file, and several are merged in the order given. Without
+// --config a single file is located following XDG rules and respecting the
+// LLAMA_CONFIG_PATH env var.
+func resolveConfigPaths(configFiles []string) ([]string, error) {
+	for _, configFile := range configFiles {
+		if _, err := os.Stat(configFile); err != nil {
+			return nil, fmt.Errorf("config file specified by --config not found: %s", configFile)
+		}
+	}
+	if len(configFiles) > 0 {
+		return configFiles, nil
+	}
+	return []string{resolveConfigPath()}, nil
 }
 
-// resolveConfigPath determines the configuration file location following XDG rules
-// and respecting the LLAMA_CONFIG_PATH env var and the --config flag.
+// resolveConfigPath determines the default configuration file location
+// following XDG rules and respecting the LLAMA_CONFIG_PATH env var.
 func resolveConfigPath() string {
 	const defaultPath = "./config.yaml"
 
@@ -345,18 +833,6 @@ func resolveConfigPath() string {
 		candidatePaths = []string{val}
 	}
 
-	for i := 1; i < len(os.Args)-1; i++ {
-		if os.Args[i] == "--config" {
-			configFile := os.Args[i+1]
-			if _, err := os.Stat(configFile); err != nil {
-				fmt.Printf("Config file specified by --config not found: %s\n", configFile)
-				os.Exit(1)
-			}
-			candidatePaths = []string{configFile}
-			break
-		}
-	}
-
 	configFile := defaultPath
 	for _, p := range candidatePaths {
 		if _, err := os.Stat(p); err == nil {
@@ -367,60 +843,44 @@ func resolveConfigPath() string {
 	return configFile
 }
 
- // startSignalForwarder forwards termination‑type signals to the child process
- // (and any of its descendants). It listens for a broad set of signals that
- // containers may receive and forwards each one to the child’s process group.
- func startSignalForwarder(cmd *exec.Cmd, debug bool) {
-	 // Buffered channel so we don’t miss signals while the goroutine is starting.
-	 sigChan := make(chan os.Signal, 1)
-
-	 // Signals we want to forward. SIGKILL and SIGSTOP cannot be caught, so they are omitted.
-	 signals := []os.Signal{
-		 syscall.SIGINT,
-		 syscall.SIGTERM,
-		 syscall.SIGQUIT,
-		 syscall.SIGHUP,
-		 syscall.SIGUSR1,
-		 syscall.SIGUSR2,
-	 }
-
-	 signal.Notify(sigChan, signals...)
-
-	 go func() {
-		 for sig := range sigChan {
-			 if debug {
-				 fmt.Printf("Received signal: %v. Forwarding to llama-server (pgid %d)...\n", sig, cmd.Process.Pid)
-			 }
-			 // Forward to the whole process group. Negative PID means “process group”.
-			 if cmd.Process != nil {
-				 if err := syscall.Kill(-cmd.Process.Pid, sig.(syscall.Signal)); err != nil && debug {
-					 fmt.Printf("Failed to forward signal %v: %v\n", sig, err)
-				 }
-			 }
-		 }
-	 }()
- }
-
-// runCommand executes the command and returns an appropriate exit code.
-func runCommand(cmd *exec.Cmd, debug bool) int {
-	err := cmd.Run()
-	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			waitStatus := exitError.Sys().(syscall.WaitStatus)
-			if debug {
-				fmt.Printf("llama-server exited with status: %d\n", waitStatus.ExitStatus())
-			}
-			return waitStatus.ExitStatus()
+// setFieldValue parses a string into a LlamaConfig field according to the
+// field's type. For list fields the value is appended.
+func setFieldValue(field reflect.Value, raw string) error {
+	if field.CanAddr() {
+		if u, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(raw))
 		}
-		if debug {
-			fmt.Printf("Failed to run llama-server: %v\n", err)
-		}
-		return 1
 	}
-	if debug {
-		fmt.Println("llama-server exited successfully.")
+	switch field.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		field.SetBool(b)
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int:
+		i, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		field.SetInt(int64(i))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported config field type: %s", field.Type())
+		}
+		field.Set(reflect.Append(field, reflect.ValueOf(raw)))
+	default:
+		return fmt.Errorf("unsupported config field type: %s", field.Kind())
 	}
-	return 0
+	return nil
 }
 
 // buildArgs uses reflection to dynamically build command-line arguments
@@ -430,7 +890,8 @@ func buildArgs(config *LlamaConfig) ([]string, error) {
 	val := reflect.ValueOf(config).Elem() // Get the value of the struct
 	typ := val.Type()                     // Get the type of the struct
 
-	// Iterate over all the fields of the struct.
+	// Iterate over all the fields of the struct. Fields without an `arg`
+	// tag, such as extra-args, are skipped.
 	for i := 0; i < val.NumField(); i++ {
 		field := val.Field(i)
 		fieldType := typ.Field(i)
@@ -442,19 +903,27 @@ func buildArgs(config *LlamaConfig) ([]string, error) {
 		}
 
 		// Skip fields with zero values (e.g., empty strings, 0, false),
-		// so we don't pass empty flags to the server.
-		if field.IsZero() {
+		// so we don't pass empty flags to the server, unless the user
+		// explicitly wrote the key in the YAML.
+		if field.IsZero() && !config.isSet(fieldType.Tag.Get("yaml")) {
 			continue
 		}
 
 		// Handle different field types.
 		switch field.Kind() {
 		case reflect.Bool:
-			// For booleans, if true, just add the flag.
+			// For booleans, if true, just add the flag. An explicit false
+			// is only passed on for flags that have a negated form.
 			if field.Bool() {
 				args = append(args, argTag)
+			} else if negTag := fieldType.Tag.Get("neg"); negTag != "" {
+				args = append(args, negTag)
 			}
 		case reflect.String:
+			// An empty string has no meaningful value to pass on.
+			if field.String() == "" {
+				continue
+			}
 			args = append(args, argTag, field.String())
 		case reflect.Int:
 			args = append(args, argTag, strconv.FormatInt(field.Int(), 10))
@@ -472,6 +941,9 @@ func buildArgs(config *LlamaConfig) ([]string, error) {
 		}
 	}
 
+	// Extra arguments go last, so they can override earlier options.
+	args = append(args, config.ExtraArgs...)
+
 	return args, nil
 }
 
@@ -479,10 +951,8 @@ func buildArgs(config *LlamaConfig) ([]string, error) {
 func formatArgsForDisplay(args []string) string {
 	var result string
 	for i, arg := range args {
-		// Add quotes around arguments that contain spaces
-		if containsSpace(arg) {
-			arg = "\"" + arg + "\""
-		}
+		// Quote arguments so the output can be pasted into a shell
+		arg = shellQuote(arg)
 
 		// Add a newline and indentation for each flag (arguments starting with --)
 		if i > 0 && strings.HasPrefix(arg, "--") {
@@ -496,6 +966,35 @@ func formatArgsForDisplay(args []string) string {
 	return result
 }
 
+// shellQuote quotes an argument, if needed, so that a POSIX shell reads it back
+// unchanged. Arguments containing whitespace are double-quoted for
+// readability, unless they contain characters that are special inside double
+// quotes; any other argument that needs quoting, such as one with glob
+// characters, is single-quoted.
+func shellQuote(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	if !strings.ContainsFunc(arg, needsShellQuote) {
+		return arg
+	}
+	if containsSpace(arg) && !strings.ContainsAny(arg, "\"\\$`!") {
+		return "\"" + arg + "\""
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// needsShellQuote reports whether a character is special to a POSIX shell.
+func needsShellQuote(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	case strings.ContainsRune("-_./:=,+@%^", r):
+		return false
+	}
+	return true
+}
+
 // containsSpace checks if a string contains any whitespace
 func containsSpace(s string) bool {
 	for _, r := range s {
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestBlueGreen tests switching to a new server on SIGHUP without failing
+// any request, and keeping the running server when the new one fails
+func TestBlueGreen(t *testing.T) {
+	origExecCommand := execCommand
+	defer func() { execCommand = origExecCommand }()
+	execCommand = mockExecCommandWithEnv("MOCK_SERVE=1", "MOCK_FAIL_ALIAS=broken")
+
+	port := freePort(t)
+	url := fmt.Sprintf("http://127.0.0.1:%d/completion", port)
+	serversFor := func(alias string) []server {
+		return []server{{
+			config: &LlamaConfig{Alias: alias, Port: port},
+			args:   []string{"--port", strconv.Itoa(port), "--alias", alias},
+		}}
+	}
+	var alias atomic.Value
+	alias.Store("a")
+
+	get := func() (string, error) {
+		resp, err := http.Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("GET %s = %s", url, resp.Status)
+		}
+		return string(body), nil
+	}
+	waitFor := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			got, err := get()
+			if got == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("GET %s = %q, %v, want %q", url, got, err, want)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	reload := func(to string) {
+		alias.Store(to)
+		syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+	}
+
+	// waitReplaced waits for the server being replaced, or replacing the
+	// running one, to exit.
+	waitReplaced := func(sup *supervisor) {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			sup.mu.Lock()
+			n := len(sup.replacing)
+			sup.mu.Unlock()
+			if n == 0 {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	servers := serversFor("a")
+	var hash string
+	out := captureStdout(t, func() {
+		sup := newSupervisor(servers[0].config, servers)
+		sup.reload = func() (*LlamaConfig, []server, error) {
+			servers := serversFor(alias.Load().(string))
+			return servers[0].config, servers, nil
+		}
+		done := make(chan int)
+		go func() { done <- runBlueGreen(servers[0].config, sup) }()
+		defer func() {
+			syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+			if got := <-done; got != 0 {
+				t.Errorf("runBlueGreen() = %d, want 0", got)
+			}
+		}()
+		waitFor("a /completion")
+
+		// Requests keep being answered while the servers are switched.
+		var failures atomic.Int64
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := get(); err != nil {
+					failures.Add(1)
+				}
+			}
+		}()
+		reload("b")
+		waitFor("b /completion")
+		waitReplaced(sup)
+		close(stop)
+		wg.Wait()
+		if n := failures.Load(); n > 0 {
+			t.Errorf("%d requests failed during the switch", n)
+		}
+
+		reload("broken")
+		time.Sleep(200 * time.Millisecond)
+		waitReplaced(sup)
+		waitFor("b /completion")
+		hash = sup.status().ConfigHash
+	})
+
+	for _, want := range []string{
+		`msg="Reloading the configuration" event=reload trigger=SIGHUP`,
+		`msg="Switched to the new llama-server, draining the old one" event=switch`,
+		`msg="llama-server exited successfully" event=exit`,
+		"stopped_by=SIGTERM\n",
+		`level=ERROR msg="Keeping the running llama-server, as the new one is not ready" event=reload`,
+		`error="it exited before it was ready: llama-server exited with status: 1"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	if want := configHash(serversFor("b")); hash != want {
+		t.Errorf("config hash = %s, want the reloaded configuration's %s", hash, want)
+	}
+}
+
+// TestBlueGreenReadyTimeout tests giving up on a new server that never
+// becomes ready, even without health.startup-timeout
+func TestBlueGreenReadyTimeout(t *testing.T) {
+	bg := &blueGreen{readyTimeout: 300 * time.Millisecond}
+	b := &backend{health: newHealthCheck(&LlamaConfig{Port: freePort(t)}), exited: make(chan struct{})}
+	err := bg.waitReady(b)
+	if want := "it was not ready within 300ms"; err == nil || err.Error() != want {
+		t.Errorf("waitReady() error = %v, want %q", err, want)
+	}
+
+	b.health.startupTimeout = 100 * time.Millisecond
+	err = bg.waitReady(b)
+	if want := "it was not ready within 100ms"; err == nil || err.Error() != want {
+		t.Errorf("waitReady() error = %v, want %q", err, want)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"os"
 	"strings"
@@ -10,6 +11,7 @@ import (
 	"syscall"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 )
 
 // TestDebugMode tests the --debug flag functionality
@@ -18,9 +20,9 @@ func TestDebugMode(t *testing.T) {
 	oldArgs := os.Args
 	defer func() { os.Args = oldArgs }()
 
-	// Create a valid config file
+	// Create a valid config file, with a model file that exists
 	validConfig := `
-model: /path/to/model.gguf
+model: ` + createTempModel(t) + `
 host: 0.0.0.0
 port: 8080
 `
@@ -38,7 +40,9 @@ port: 8080
 		os.Stdout = w
 
 		// Run main in a subprocess so os.Exit does not kill the test.
-		cmd := exec.Command("./llauncher", "--debug", "--config", validFile)
+		// A stub llama-server on PATH stands in for the real server.
+		cmd := exec.Command(buildLlauncher(t), "--debug", "--config", validFile)
+		cmd.Env = append(os.Environ(), "PATH="+createStubServer(t, "exit 0")+string(os.PathListSeparator)+os.Getenv("PATH"))
 		// Suppress output; we will read from the pipe.
 		cmd.Stdout = w
 		cmd.Stderr = w
@@ -59,12 +63,36 @@ port: 8080
 		io.Copy(&buf, r)
 
 		output := buf.String()
-		if !strings.Contains(output, "DEBUG:") {
+		if !strings.Contains(output, "level=DEBUG") || !strings.Contains(output, "event=command") {
 			t.Fatalf("expected debug output, got: %s", output)
 		}
 	})
 }
 
+// createStubServer writes an executable llama-server shell script with the
+// given body into a temporary directory and returns that directory, for tests
+// which run the llauncher binary as a subprocess.
+func createStubServer(t *testing.T, body string) string {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\n" + body + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "llama-server"), []byte(script), 0o755); err != nil {
+		t.Fatalf("Failed to write stub llama-server: %v", err)
+	}
+	return dir
+}
+
+// buildLlauncher builds llauncher from the source under test, for tests that
+// run it as a subprocess, and returns the binary's path.
+func buildLlauncher(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "llauncher")
+	if out, err := exec.Command("go", "build", "-o", path, ".").CombinedOutput(); err != nil {
+		t.Fatalf("Failed to build llauncher: %v\n%s", err, out)
+	}
+	return path
+}
+
 // TestSignalHandling tests the signal handling functionality
 func TestSignalHandling(t *testing.T) {
 	// Helper to capture and discard stdout/stderr.
@@ -89,7 +117,7 @@ func TestSignalHandling(t *testing.T) {
 
 		// Create a temporary config file (minimal, just model)
 		cfg := `
-model: /tmp/dummy.gguf
+model: ` + createTempModel(t) + `
 `
 		cfgFile := createTempFile(t, cfg)
 		defer os.Remove(cfgFile)
@@ -99,7 +127,7 @@ model: /tmp/dummy.gguf
 		// when it receives SIGTERM.
 		mockCmd := func(name string, args ...string) *exec.Cmd {
 			// Use the same test binary with a special env var.
-			cmd := exec.Command(os.Args[0], "-test.run=MockSignalReceiver")
+			cmd := exec.Command(testBinary, "-test.run=MockSignalReceiver")
 			cmd.Env = append(os.Environ(), "MOCK_SIGNAL=1")
 			return cmd
 		}
@@ -112,7 +140,7 @@ model: /tmp/dummy.gguf
 		go func() {
 			// Set args to include config file.
 			os.Args = []string{"llauncher", "--config", cfgFile}
-			main()
+			run()
 			close(done)
 		}()
 
@@ -143,3 +171,89 @@ func TestMockSignalReceiver(t *testing.T) {
 	// Received signal, exit with success.
 	os.Exit(0)
 }
+
+// TestJSONLog tests that llauncher logs its own messages to stderr as JSON,
+// apart from llama-server's output on stdout
+func TestJSONLog(t *testing.T) {
+	configFile := createTempFile(t, "model: "+createTempModel(t)+"\n")
+	defer os.Remove(configFile)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(buildLlauncher(t), "--log-format", "json", "--log-level", "debug", "--config", configFile)
+	cmd.Env = append(os.Environ(), "PATH="+createStubServer(t, "echo server output")+string(os.PathListSeparator)+os.Getenv("PATH"))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("error running llauncher: %v\n%s", err, stderr.String())
+	}
+
+	if got := stdout.String(); got != "server output\n" {
+		t.Errorf("stdout = %q, want only llama-server's output", got)
+	}
+	events := make(map[string]map[string]any)
+	for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("stderr line is not JSON: %q", line)
+		}
+		event, _ := record["event"].(string)
+		if event == "" {
+			t.Errorf("record has no event: %q", line)
+		}
+		events[event] = record
+	}
+	if paths, _ := events["command"]["config_path"].([]any); len(paths) != 1 || paths[0] != configFile {
+		t.Errorf("command record = %v, want config_path [%s]", events["command"], configFile)
+	}
+	if exit := events["exit"]; exit == nil || exit["exit_code"] != 0.0 || exit["pid"] == nil {
+		t.Errorf("exit record = %v, want exit_code 0 and a pid", exit)
+	}
+}
+
+// TestLogConfigValues tests logging where each configuration value came from,
+// with secrets redacted
+func TestLogConfigValues(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"models.yaml": "models:\n  - {model: /models/a.gguf, alias: a, api-key: sk-model-secret}\n  - {model: /models/b.gguf, alias: b}\nprofiles:\n  secure:\n    api-key: sk-profile-secret\n",
+	})
+	path := filepath.Join(dir, "models.yaml")
+	config, err := loadConfigFiles([]string{path}, loadOptions{Strict: true, Profile: "secure"})
+	if err != nil {
+		t.Fatalf("loadConfigFiles() error = %v", err)
+	}
+
+	out := captureStdout(t, func() { logConfigValues(config) })
+	for _, line := range []string{
+		`msg="Configuration value" event=config key=models[0].model value=/models/a.gguf origin=` + path + ":2\n",
+		"key=models[0].api-key value=<redacted>",
+		"key=models[1].api-key value=<redacted>",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("output does not contain %q:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Errorf("output contains a secret:\n%s", out)
+	}
+}
+
+// TestJSONLogErrors tests that configuration errors are logged as JSON too
+func TestJSONLogErrors(t *testing.T) {
+	configFile := createTempFile(t, "model: "+createTempModel(t)+"\nport: 0\n")
+	defer os.Remove(configFile)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(buildLlauncher(t), "--log-format", "json", "--config", configFile)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err == nil {
+		t.Fatalf("llauncher succeeded with an invalid configuration")
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal(stderr.Bytes(), &record); err != nil {
+		t.Fatalf("stderr is not a JSON record: %q", stderr.String())
+	}
+	if errText, _ := record["error"].(string); record["level"] != "ERROR" || record["event"] != "config" || !strings.Contains(errText, "port: invalid port 0") {
+		t.Errorf("record = %v, want a config error about the port", record)
+	}
+}
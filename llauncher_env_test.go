@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestExpandEnv tests the expansion of environment variable references
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{
+		"MODEL_DIR": "/var/lib/models",
+		"PORT":      "9000",
+		"EMPTY":     "",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	tests := []struct {
+		name      string
+		in        string
+		want      string
+		wantNames []string
+		wantErr   string
+	}{
+		{name: "Plain text", in: "/models/a.gguf", want: "/models/a.gguf"},
+		{name: "Variable", in: "${MODEL_DIR}/a.gguf", want: "/var/lib/models/a.gguf", wantNames: []string{"MODEL_DIR"}},
+		{name: "Unset variable", in: "${UNSET}", want: "", wantNames: []string{"UNSET"}},
+		{name: "Default for unset", in: "${UNSET:-8080}", want: "8080", wantNames: []string{"UNSET"}},
+		{name: "Default for empty", in: "${EMPTY:-8080}", want: "8080", wantNames: []string{"EMPTY"}},
+		{name: "Default not used", in: "${PORT:-8080}", want: "9000", wantNames: []string{"PORT"}},
+		{name: "Required and set", in: "${PORT:?port is required}", want: "9000", wantNames: []string{"PORT"}},
+		{name: "Required and unset", in: "${UNSET:?port is required}", wantErr: "UNSET: port is required"},
+		{name: "Required without message", in: "${EMPTY:?}", wantErr: "EMPTY: is not set"},
+		{name: "Escaped dollar", in: "price$$5 and $$${PORT}", want: "price$5 and $9000", wantNames: []string{"PORT"}},
+		{name: "Lone dollar", in: "a$b$", want: "a$b$"},
+		{name: "Unterminated", in: "${PORT", wantErr: `unterminated variable reference in "${PORT"`},
+		{name: "Invalid name", in: "${1PORT}", wantErr: "invalid variable name in ${1PORT}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, names, err := expandEnv(tt.in, lookup)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("expandEnv() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expandEnv() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expandEnv() = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("expandEnv() names = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+// TestEnvInterpolation tests environment variable expansion in configuration files
+func TestEnvInterpolation(t *testing.T) {
+	t.Setenv("LLAUNCHER_TEST_MODEL_DIR", "/var/lib/models")
+	t.Setenv("LLAUNCHER_TEST_PORT", "9000")
+	t.Setenv("LLAUNCHER_TEST_API_KEY", "sk-secret")
+	t.Setenv("LLAUNCHER_TEST_ALIAS", "model: with # yaml syntax")
+
+	yaml := `
+model: ${LLAUNCHER_TEST_MODEL_DIR}/a.gguf
+port: ${LLAUNCHER_TEST_PORT}
+ctx-size: ${LLAUNCHER_TEST_CTX:-4096}
+alias: ${LLAUNCHER_TEST_ALIAS}
+api-key: "${LLAUNCHER_TEST_API_KEY}"
+chat-template-kwargs: '{"cost": "$$5"}'
+lora:
+  - ${LLAUNCHER_TEST_MODEL_DIR}/lora.gguf
+`
+	tmpfile := createTempFile(t, yaml)
+	defer os.Remove(tmpfile)
+
+	config, err := loadConfig(tmpfile)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+
+	if config.ModelPath != "/var/lib/models/a.gguf" {
+		t.Errorf("ModelPath = %q", config.ModelPath)
+	}
+	if config.Port != 9000 {
+		t.Errorf("Port = %d, want 9000", config.Port)
+	}
+	if config.ContextSize != 4096 {
+		t.Errorf("ContextSize = %d, want 4096", config.ContextSize)
+	}
+	if config.Alias != "model: with # yaml syntax" {
+		t.Errorf("Alias = %q", config.Alias)
+	}
+	if config.ApiKey != "sk-secret" {
+		t.Errorf("ApiKey = %q", config.ApiKey)
+	}
+	if config.ChatTemplateKwargs != `{"cost": "$5"}` {
+		t.Errorf("ChatTemplateKwargs = %q", config.ChatTemplateKwargs)
+	}
+	if len(config.LoraAdapters) != 1 || config.LoraAdapters[0] != "/var/lib/models/lora.gguf" {
+		t.Errorf("LoraAdapters = %v", config.LoraAdapters)
+	}
+
+	secrets := make(map[string]bool)
+	for _, e := range config.expansions {
+		secrets[e.Key] = e.Secret
+	}
+	want := map[string]bool{
+		"model":                false,
+		"port":                 false,
+		"ctx-size":             false,
+		"alias":                false,
+		"api-key":              true,
+		"chat-template-kwargs": false,
+		"lora[0]":              false,
+	}
+	if !reflect.DeepEqual(secrets, want) {
+		t.Errorf("expansions secrets = %v, want %v", secrets, want)
+	}
+}
+
+// TestEnvInterpolationErrors tests that expansion errors are reported with
+// their position
+func TestEnvInterpolationErrors(t *testing.T) {
+	yaml := `
+model: /models/a.gguf
+port: ${LLAUNCHER_TEST_UNSET_PORT:?set the server port}
+`
+	tmpfile := createTempFile(t, yaml)
+	defer os.Remove(tmpfile)
+
+	_, err := loadConfig(tmpfile)
+	var errs configErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("loadConfig() error = %v, want configErrors", err)
+	}
+	want := tmpfile + ":3:7: port: LLAUNCHER_TEST_UNSET_PORT: set the server port"
+	if len(errs) != 1 || errs[0].Error() != want {
+		t.Errorf("loadConfig() error = %v, want %q", err, want)
+	}
+}
+
+// TestRedactArgs tests hiding secret values in displayed commands
+func TestRedactArgs(t *testing.T) {
+	args := []string{"--model", "a.gguf", "--api-key", "sk-secret", "--hf-token", "hf_secret", "--port", "8080"}
+	want := []string{"--model", "a.gguf", "--api-key", "<redacted>", "--hf-token", "<redacted>", "--port", "8080"}
+	if got := redactArgs(args); !reflect.DeepEqual(got, want) {
+		t.Errorf("redactArgs() = %v, want %v", got, want)
+	}
+	if args[3] != "sk-secret" {
+		t.Errorf("redactArgs() modified its input")
+	}
+
+	if !isSecret("alias", []string{"OPENAI_API_KEY"}) {
+		t.Errorf("isSecret() = false for a value from an API key variable")
+	}
+	if isSecret("model", []string{"MODEL_DIR"}) {
+		t.Errorf("isSecret() = true for a model path")
+	}
+}
+
+// TestEnvOverrides tests overriding configuration keys with LLAUNCHER_* variables
+func TestEnvOverrides(t *testing.T) {
+	yaml := `
+model: /models/a.gguf
+n-gpu-layers: 99
+lora: [/loras/a.gguf]
+`
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantArgs []string
+		wantErr  string
+	}{
+		{
+			name:     "No overrides",
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--lora", "/loras/a.gguf"},
+		},
+		{
+			name:     "Override an integer",
+			env:      map[string]string{"LLAUNCHER_N_GPU_LAYERS": "0"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "0", "--lora", "/loras/a.gguf"},
+		},
+		{
+			name:     "Add a key",
+			env:      map[string]string{"LLAUNCHER_CTX_SIZE": "65536", "LLAUNCHER_TEMP": "0.7"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--ctx-size", "65536", "--temp", "0.7", "--lora", "/loras/a.gguf"},
+		},
+		{
+			name:     "Replace a list",
+			env:      map[string]string{"LLAUNCHER_LORA": "/loras/b.gguf, /loras/c.gguf"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--lora", "/loras/b.gguf", "--lora", "/loras/c.gguf"},
+		},
+		{
+			name:     "Boolean false uses the negated flag",
+			env:      map[string]string{"LLAUNCHER_CONT_BATCHING": "false"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--lora", "/loras/a.gguf", "--no-cont-batching"},
+		},
+		{
+			name:     "Empty value is ignored",
+			env:      map[string]string{"LLAUNCHER_N_GPU_LAYERS": ""},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--lora", "/loras/a.gguf"},
+		},
+		{
+			name:    "Invalid values are all reported",
+			env:     map[string]string{"LLAUNCHER_N_GPU_LAYERS": "all", "LLAUNCHER_JINJA": "maybe"},
+			wantErr: "LLAUNCHER_N_GPU_LAYERS: invalid integer \"all\"\nLLAUNCHER_JINJA: invalid boolean \"maybe\"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpFile := createTempFile(t, yaml)
+			defer os.Remove(tmpFile)
+
+			config, err := loadConfig(tmpFile)
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			lookup := func(name string) (string, bool) {
+				value, ok := tt.env[name]
+				return value, ok
+			}
+			err = applyEnvOverrides(config, lookup)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("applyEnvOverrides() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyEnvOverrides() error = %v", err)
+			}
+
+			args, err := buildArgs(config)
+			if err != nil {
+				t.Fatalf("buildArgs() error = %v", err)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("buildArgs() = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+// TestEnvOverridePrecedence tests that environment overrides apply between the
+// configuration files and the command line
+func TestEnvOverridePrecedence(t *testing.T) {
+	model := createTempModel(t)
+	tmpFile := createTempFile(t, "model: "+model+"\nn-gpu-layers: 99\n")
+	defer os.Remove(tmpFile)
+	t.Setenv("LLAUNCHER_N_GPU_LAYERS", "40")
+
+	config, servers, err := prepareServers([]string{tmpFile}, loadOptions{Strict: true}, nil, []string{"--n-gpu-layers", "20"})
+	if err != nil {
+		t.Fatalf("prepareServers() error = %v", err)
+	}
+	want := []string{"--model", model, "--n-gpu-layers", "40", "--n-gpu-layers", "20"}
+	if len(servers) != 1 || !reflect.DeepEqual(servers[0].args, want) {
+		t.Errorf("prepareServers() = %+v, want one server with %v", servers, want)
+	}
+
+	last := config.sources[len(config.sources)-1]
+	if last.Key != "n-gpu-layers" || last.Origin != "environment variable LLAUNCHER_N_GPU_LAYERS" {
+		t.Errorf("source = %+v, want n-gpu-layers from LLAUNCHER_N_GPU_LAYERS", last)
+	}
+}
+
+// TestEnvOverridesMultiModel tests that options each model needs its own value
+// for cannot be overridden for every model
+func TestEnvOverridesMultiModel(t *testing.T) {
+	model := createTempModel(t)
+	tmpFile := createTempFile(t, "models:\n  - {model: "+model+", alias: a, port: 9001}\n  - {model: "+model+", alias: b, port: 9002}\n")
+	defer os.Remove(tmpFile)
+
+	t.Setenv("LLAUNCHER_CTX_SIZE", "4096")
+	_, servers, err := prepareServers([]string{tmpFile}, loadOptions{Strict: true}, nil, nil)
+	if err != nil {
+		t.Fatalf("prepareServers() error = %v", err)
+	}
+	for _, srv := range servers {
+		if !strings.Contains(strings.Join(srv.args, " "), "--ctx-size 4096") {
+			t.Errorf("%s args = %v, want --ctx-size 4096", srv.name, srv.args)
+		}
+	}
+
+	for _, name := range []string{"LLAUNCHER_PORT", "LLAUNCHER_ALIAS"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, "9000")
+			_, _, err := prepareServers([]string{tmpFile}, loadOptions{Strict: true}, nil, nil)
+			if want := name + ": cannot be overridden for every model"; err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("prepareServers() error = %v, want %q", err, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestHealthProbe tests asking llama-server for its health, under its API
+// prefix and with its API key
+func TestHealthProbe(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	srv := stubUpstream(t, "a", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/llama/health" || r.Header.Get("Authorization") != "Bearer key-1" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(status)
+	})
+	srv.config.ApiPrefix = "/llama"
+	srv.config.ApiKey = "key-1,key-2"
+	h := newHealthCheck(srv.config)
+
+	for _, tt := range []struct {
+		status int
+		want   healthState
+	}{
+		{status: http.StatusServiceUnavailable, want: healthLoading},
+		{status: http.StatusOK, want: healthReady},
+		{status: http.StatusInternalServerError, want: healthUnhealthy},
+	} {
+		status = tt.status
+		if got, _ := h.probe(context.Background()); got != tt.want {
+			t.Errorf("probe() with status %d = %s, want %s", tt.status, got, tt.want)
+		}
+	}
+
+	srv.config.Port = freePort(t)
+	if got, _ := newHealthCheck(srv.config).probe(context.Background()); got != healthStarting {
+		t.Errorf("probe() without a server = %s, want %s", got, healthStarting)
+	}
+}
+
+// TestUpstreamAPIKey tests finding the API key llama-server accepts
+func TestUpstreamAPIKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keyFile, []byte("\n  file-key  \nother-key\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	for _, tt := range []struct {
+		config LlamaConfig
+		want   string
+	}{
+		{config: LlamaConfig{}, want: ""},
+		{config: LlamaConfig{ApiKey: "key-1, key-2"}, want: "key-1"},
+		{config: LlamaConfig{ApiKeyFile: keyFile}, want: "file-key"},
+	} {
+		if got := upstreamAPIKey(&tt.config); got != tt.want {
+			t.Errorf("upstreamAPIKey(%+v) = %q, want %q", tt.config, got, tt.want)
+		}
+	}
+}
+
+// TestHealthMonitor tests tracking a server's health, and killing it when it
+// does not become ready or stops being healthy
+func TestHealthMonitor(t *testing.T) {
+	origExecCommand := execCommand
+	defer func() { execCommand = origExecCommand }()
+
+	tests := []struct {
+		name    string
+		env     []string
+		health  HealthConfig
+		restart RestartConfig
+		stop    bool
+		want    int
+		wantOut []string
+	}{
+		{
+			name:    "Ready",
+			env:     []string{"MOCK_SERVE=1"},
+			health:  HealthConfig{Enabled: true, Interval: "50ms"},
+			stop:    true,
+			want:    0,
+			wantOut: []string{`level=INFO msg="llama-server is ready" event=health`},
+		},
+		{
+			name:   "Startup timeout",
+			env:    []string{"MOCK_SERVE=1", "MOCK_HEALTH=loading"},
+			health: HealthConfig{Enabled: true, Interval: "50ms", StartupTimeout: "300ms"},
+			want:   128 + 9,
+			wantOut: []string{
+				`msg="llama-server is loading the model" event=health`,
+				`level=ERROR msg="llama-server did not become ready in time, killing it" event=health`,
+				"signal=SIGKILL timeout=300ms\n",
+			},
+		},
+		{
+			name:    "Failure threshold with restart",
+			env:     []string{"MOCK_SERVE=1", "MOCK_HEALTHY_CHECKS=2"},
+			health:  HealthConfig{Enabled: true, Interval: "50ms", FailureThreshold: 2},
+			restart: RestartConfig{Policy: restartOnFailure, MaxRetries: 1, Backoff: "10ms"},
+			want:    128 + 9,
+			wantOut: []string{
+				`msg="llama-server is ready"`,
+				`level=WARN msg="llama-server is unhealthy" event=health`,
+				`state=unhealthy error="/health returned 500 Internal Server Error"`,
+				`msg="llama-server failed its health checks, killing it" event=health`,
+				`signal=SIGKILL failures=2 error=`,
+				`msg="llama-server was killed by signal: killed, restarting" event=restart`,
+				`msg="llama-server was killed by signal: killed, giving up" event=restart`,
+				"restarts=1\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execCommand = mockExecCommandWithEnv(tt.env...)
+			servers := swapServers(t, "a")
+			servers[0].name = ""
+			servers[0].config.Health = tt.health
+			servers[0].config.Restart = tt.restart
+
+			out := captureStdout(t, func() {
+				if tt.stop {
+					go func() {
+						time.Sleep(500 * time.Millisecond)
+						syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+					}()
+				}
+				if got := newSupervisor(&LlamaConfig{}, servers).run(); got != tt.want {
+					t.Errorf("run() = %d, want %d", got, tt.want)
+				}
+			})
+			for _, line := range tt.wantOut {
+				if !strings.Contains(out, line) {
+					t.Errorf("output does not contain %q:\n%s", line, out)
+				}
+			}
+		})
+	}
+}
+
+// TestValidateHealth tests the checks of the health check settings
+func TestValidateHealth(t *testing.T) {
+	errs := validateHealth(HealthConfig{Interval: "0s", Timeout: "fast", FailureThreshold: -2})
+	want := []string{
+		`health.timeout: invalid duration "fast", e.g. 30s or 10m`,
+		"health.interval: must be greater than zero",
+		"health.failure-threshold: invalid value -2, must not be negative",
+	}
+	if got := strings.Split(errs.Error(), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("validateHealth() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestImportArgs tests mapping llama-server command lines onto LlamaConfig
+func TestImportArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		argv      []string
+		wantYAML  string
+		wantExtra []string
+	}{
+		{
+			name: "Short forms and aliases",
+			argv: []string{"/app/llama-server", "-m", "/models/a.gguf", "-ngl", "99", "-c", "32768", "-fa", "--jinja", "-a", "model-a"},
+			wantYAML: `model: /models/a.gguf
+alias: model-a
+n-gpu-layers: 99
+ctx-size: 32768
+flash-attn: "on"
+jinja: true
+`,
+		},
+		{
+			name: "Long alias and inline values",
+			argv: []string{"--gpu-layers=0", "--temp=0.7", "--port", "9000"},
+			wantYAML: `port: 9000
+n-gpu-layers: 0
+temp: 0.7
+`,
+		},
+		{
+			name: "Repeated and negative values",
+			argv: []string{"llama-server", "--lora", "a.gguf", "--lora", "b.gguf", "-s", "-1"},
+			wantYAML: `seed: -1
+lora:
+  - a.gguf
+  - b.gguf
+`,
+		},
+		{
+			name: "Negated and on/off bool flags",
+			argv: []string{"-nocb", "--no-context-shift", "-fa", "off"},
+			wantYAML: `flash-attn: "off"
+no-cont-batching: true
+no-context-shift: true
+`,
+		},
+		{
+			name: "Flash attention modes",
+			argv: []string{"-fa", "auto", "-m", "a.gguf"},
+			wantYAML: `model: a.gguf
+flash-attn: auto
+`,
+		},
+		{
+			name: "Inline flash attention mode",
+			argv: []string{"--flash-attn=auto"},
+			wantYAML: `flash-attn: auto
+`,
+		},
+		{
+			name: "Flash attention flag before a stray argument",
+			argv: []string{"-fa", "stray"},
+			wantYAML: `flash-attn: "on"
+# Options that could not be mapped to llauncher keys, passed verbatim
+extra-args:
+  - stray
+`,
+			wantExtra: []string{"stray"},
+		},
+		{
+			name: "Unmapped options",
+			argv: []string{"-m", "a.gguf", "--brand-new", "5", "--new-flag", "-t", "four", "stray"},
+			wantYAML: `model: a.gguf
+# Options that could not be mapped to llauncher keys, passed verbatim
+extra-args:
+  - --brand-new
+  - "5"
+  - --new-flag
+  - -t
+  - four
+  - stray
+`,
+			wantExtra: []string{"--brand-new", "5", "--new-flag", "-t", "four", "stray"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := importArgs(tt.argv)
+			if !reflect.DeepEqual([]string(config.ExtraArgs), tt.wantExtra) {
+				t.Errorf("ExtraArgs = %q, want %q", config.ExtraArgs, tt.wantExtra)
+			}
+			out, err := marshalConfig(config)
+			if err != nil {
+				t.Fatalf("marshalConfig() error = %v", err)
+			}
+			if string(out) != tt.wantYAML {
+				t.Errorf("marshalConfig() =\n%s\nwant\n%s", out, tt.wantYAML)
+			}
+		})
+	}
+}
+
+// TestImportRoundTrip tests that an imported configuration gives back the
+// original options in their long form
+func TestImportRoundTrip(t *testing.T) {
+	argv := []string{"llama-server", "-m", "/models/a.gguf", "-ngl", "0", "-c", "8192", "--temp", "0", "-nocb", "-ctk", "q8_0", "--new-flag", "x"}
+	out, err := marshalConfig(importArgs(argv))
+	if err != nil {
+		t.Fatalf("marshalConfig() error = %v", err)
+	}
+
+	tmpfile := createTempFile(t, string(out))
+	defer os.Remove(tmpfile)
+	config, err := loadConfig(tmpfile)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	got, err := buildArgs(config)
+	if err != nil {
+		t.Fatalf("buildArgs() error = %v", err)
+	}
+
+	want := []string{
+		"--model", "/models/a.gguf",
+		"--n-gpu-layers", "0",
+		"--ctx-size", "8192",
+		"--cache-type-k", "q8_0",
+		"--temp", "0",
+		"--no-cont-batching",
+		"--new-flag", "x",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildArgs() = %v, want %v", got, want)
+	}
+}
+
+// TestImportCommand tests writing the imported configuration to a file
+func TestImportCommand(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "imported.yaml")
+
+	var stdout, stderr bytes.Buffer
+	code := runImport([]string{"-o", output, "--", "llama-server", "-m", "a.gguf", "--brand-new"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("runImport() = %d, want 0; stderr: %s", code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "could not map --brand-new") {
+		t.Errorf("stderr = %q, want a note about unmapped options", stderr.String())
+	}
+	written, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !strings.HasPrefix(string(written), "model: a.gguf\n") {
+		t.Errorf("written configuration = %q", written)
+	}
+
+	// An existing file is not overwritten.
+	stderr.Reset()
+	if code := runImport([]string{"-o", output, "llama-server", "-m", "b.gguf"}, &stdout, &stderr); code != 1 {
+		t.Fatalf("runImport() = %d, want 1", code)
+	}
+	if rewritten, _ := os.ReadFile(output); !bytes.Equal(rewritten, written) {
+		t.Errorf("existing file was overwritten")
+	}
+
+	// A command line is required.
+	if code := runImport(nil, &stdout, &stderr); code != 2 {
+		t.Errorf("runImport() = %d, want 2", code)
+	}
+}
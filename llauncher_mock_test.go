@@ -1,20 +1,43 @@
 package main
 
 import (
+	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 )
 
+// testBinary is the path of the running test binary. It is captured at
+// start-up because tests overwrite os.Args to simulate llauncher's command line.
+var testBinary = os.Args[0]
+
 // mockExecCommand is used to mock the exec.Command function for testing
 func mockExecCommand(command string, args ...string) *exec.Cmd {
 	cs := []string{"-test.run=TestHelperProcess", "--", command}
 	cs = append(cs, args...)
-	cmd := exec.Command(os.Args[0], cs...)
+	cmd := exec.Command(testBinary, cs...)
 	cmd.Env = []string{"GO_WANT_HELPER_PROCESS=1"}
 	return cmd
 }
 
+// mockExecCommandWithEnv returns an exec.Command replacement like
+// mockExecCommand that also passes extra environment variables to the helper
+// process, e.g. to select its exit behaviour.
+func mockExecCommandWithEnv(env ...string) func(string, ...string) *exec.Cmd {
+	return func(command string, args ...string) *exec.Cmd {
+		cmd := mockExecCommand(command, args...)
+		cmd.Env = append(cmd.Env, env...)
+		return cmd
+	}
+}
+
 // TestHelperProcess isn't a real test. It's used as a helper process for TestMain.
 func TestHelperProcess(t *testing.T) {
 	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
@@ -41,6 +64,21 @@ func TestHelperProcess(t *testing.T) {
 	
 	switch cmd {
 	case "llama-server":
+		// Optionally die from a signal, to mimic a crashed server.
+		if sig := os.Getenv("MOCK_KILL_SIGNAL"); sig != "" {
+			n, _ := strconv.Atoi(sig)
+			syscall.Kill(os.Getpid(), syscall.Signal(n))
+			time.Sleep(time.Second)
+		}
+		// Optionally exit with a specific status, e.g. a model load error.
+		if code := os.Getenv("MOCK_EXIT_CODE"); code != "" {
+			n, _ := strconv.Atoi(code)
+			os.Exit(n)
+		}
+		// Optionally serve a stub API, e.g. for swap mode.
+		if os.Getenv("MOCK_SERVE") == "1" {
+			serveMock(args)
+		}
 		// Mock successful execution of llama-server
 		os.Exit(0)
 	default:
@@ -49,6 +87,55 @@ func TestHelperProcess(t *testing.T) {
 	}
 }
 
+// serveMock serves a stub llama-server API on the --port in args until it is
+// sent SIGTERM. It answers /health, and any other request with its --alias
+// and the request path. With MOCK_HEALTH=loading, /health reports that the
+// model is loading; with MOCK_HEALTHY_CHECKS=n, it fails after n checks.
+func serveMock(args []string) {
+	port, alias := "8080", ""
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "--port":
+			port = args[i+1]
+		case "--alias":
+			alias = args[i+1]
+		}
+	}
+	if alias != "" && alias == os.Getenv("MOCK_FAIL_ALIAS") {
+		os.Exit(1)
+	}
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
+
+	mux := http.NewServeMux()
+	var healthy atomic.Int64
+	healthy.Store(-1)
+	if n, err := strconv.Atoi(os.Getenv("MOCK_HEALTHY_CHECKS")); err == nil {
+		healthy.Store(int64(n))
+	}
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case os.Getenv("MOCK_HEALTH") == "loading":
+			http.Error(w, `{"error":{"code":503,"message":"Loading model"}}`, http.StatusServiceUnavailable)
+		case healthy.Add(-1) == -1:
+			healthy.Store(0)
+			http.Error(w, "failing", http.StatusInternalServerError)
+		default:
+			fmt.Fprint(w, `{"status":"ok"}`)
+		}
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", alias, r.URL.Path)
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		os.Exit(1)
+	}
+	go http.Serve(listener, mux)
+	<-sigChan
+	os.Exit(0)
+}
+
  // TestMainWithMock tests the main function with a mocked exec.Command.
  // It verifies that `main` runs to completion without invoking the real
  // “llama‑server” binary.
@@ -67,7 +154,7 @@ func TestHelperProcess(t *testing.T) {
 	 // -----------------------------------------------------------------
 	 // 3️⃣  Create a minimal temporary config file.
 	 // -----------------------------------------------------------------
-	 const yaml = "model: /tmp/dummy.gguf\n"
+	 yaml := "model: " + createTempModel(t) + "\n"
 	 cfgFile := createTempFile(t, yaml)
 	 defer os.Remove(cfgFile)
 
@@ -95,10 +182,67 @@ func TestHelperProcess(t *testing.T) {
 	 }()
 
 	 // -----------------------------------------------------------------
-	 // 5️⃣  Run main().  The mock will cause the spawned “llama‑server”
-	 //    process to exit immediately with status 0, so main should return
-	 //    without calling os.Exit or panicking.
+	 // 5️⃣  Run run().  The mock will cause the spawned “llama‑server”
+	 //    process to exit immediately with status 0, so run should return
+	 //    an exit code of 0 without panicking.
 	 // -----------------------------------------------------------------
-	 main()
-	 // If we reach this point the test succeeded.
+	 if code := run(); code != 0 {
+	 	t.Fatalf("run() = %d, want 0", code)
+	 }
  }
+
+// TestExitCodePropagation tests that llama-server's exit status becomes
+// llauncher's exit code, with 128+signal when the server is killed.
+func TestExitCodePropagation(t *testing.T) {
+	origExecCommand := execCommand
+	defer func() { execCommand = origExecCommand }()
+
+	cfgFile := createTempFile(t, "model: "+createTempModel(t)+"\n")
+	defer os.Remove(cfgFile)
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	os.Args = []string{"llauncher", "--config", cfgFile}
+
+	tests := []struct {
+		name string
+		env  []string
+		want int
+	}{
+		{name: "Success", env: nil, want: 0},
+		{name: "Model load error", env: []string{"MOCK_EXIT_CODE=3"}, want: 3},
+		{name: "Killed by SIGKILL", env: []string{"MOCK_KILL_SIGNAL=9"}, want: 128 + 9},
+		{name: "Killed by SIGTERM", env: []string{"MOCK_KILL_SIGNAL=15"}, want: 128 + 15},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execCommand = mockExecCommandWithEnv(tt.env...)
+			if got := run(); got != tt.want {
+				t.Errorf("run() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("Invalid config", func(t *testing.T) {
+		badFile := createTempFile(t, "port: not-a-number\n")
+		defer os.Remove(badFile)
+		os.Args = []string{"llauncher", "--config", badFile}
+		execCommand = mockExecCommand
+
+		devNull, _ := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		origStdout := os.Stdout
+		origStderr := os.Stderr
+		os.Stdout = devNull
+		os.Stderr = devNull
+		defer func() {
+			os.Stdout = origStdout
+			os.Stderr = origStderr
+			_ = devNull.Close()
+		}()
+
+		if got := run(); got != 1 {
+			t.Errorf("run() = %d, want 1", got)
+		}
+	})
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestModelsConfig tests loading a multi-model configuration, in which each
+// model inherits the top-level options
+func TestModelsConfig(t *testing.T) {
+	yaml := `
+n-gpu-layers: 99
+lora: [/loras/a.gguf]
+exit-policy: all-fail
+models:
+  - alias: small
+    model: /models/small.gguf
+    port: 9001
+  - model: /models/large.gguf
+    port: 9002
+    n-gpu-layers: 40
+    lora: !append [/loras/b.gguf]
+`
+	tmpFile := createTempFile(t, yaml)
+	defer os.Remove(tmpFile)
+
+	config, err := loadConfig(tmpFile)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if config.ExitPolicy != exitPolicyAllFail {
+		t.Errorf("ExitPolicy = %q, want %q", config.ExitPolicy, exitPolicyAllFail)
+	}
+
+	servers, err := buildServers(config, []string{"--verbose"})
+	if err != nil {
+		t.Fatalf("buildServers() error = %v", err)
+	}
+	want := []server{
+		{name: "small", args: []string{"--port", "9001", "--model", "/models/small.gguf", "--alias", "small", "--n-gpu-layers", "99", "--lora", "/loras/a.gguf", "--verbose"}},
+		{name: "model-2", args: []string{"--port", "9002", "--model", "/models/large.gguf", "--n-gpu-layers", "40", "--lora", "/loras/a.gguf", "--lora", "/loras/b.gguf", "--verbose"}},
+	}
+	if len(servers) != len(want) {
+		t.Fatalf("buildServers() = %d servers, want %d", len(servers), len(want))
+	}
+	for i := range want {
+		if servers[i].name != want[i].name || !reflect.DeepEqual(servers[i].args, want[i].args) {
+			t.Errorf("server %d = %s %v, want %s %v", i, servers[i].name, servers[i].args, want[i].name, want[i].args)
+		}
+	}
+}
+
+// TestValidateModels tests the checks of a multi-model configuration
+func TestValidateModels(t *testing.T) {
+	model := createTempModel(t)
+
+	tests := []struct {
+		name     string
+		yaml     string
+		wantErrs []string
+	}{
+		{
+			name: "Valid",
+			yaml: "models:\n  - {model: " + model + ", alias: a, port: 9001}\n  - {model: " + model + ", alias: b, port: 9002}\n",
+		},
+		{
+			name: "Shared port",
+			yaml: "port: 9000\nmodels:\n  - {model: " + model + ", alias: a}\n  - {model: " + model + ", alias: b}\n",
+			wantErrs: []string{
+				"models[1].port: port 9000 is already used by models[0]",
+			},
+		},
+		{
+			name: "Default ports and duplicate aliases",
+			yaml: "models:\n  - {model: " + model + ", alias: a}\n  - {model: " + model + ", alias: a}\n",
+			wantErrs: []string{
+				`models[1].alias: alias "a" is already used by models[0]`,
+				"models[1].port: port 8080 is already used by models[0]",
+			},
+		},
+		{
+			name: "Errors in a model",
+			yaml: "cache-type-k: q7\nmodels:\n  - {model: " + model + ", port: 9001, exit-policy: all-fail}\n",
+			wantErrs: []string{
+				"models[0].exit-policy: can only be set at the top level, not for a single model",
+				`models[0].cache-type-k: invalid value "q7", must be one of: f32, f16, bf16, q8_0, q4_0, q4_1, iq4_nl, q5_0, q5_1`,
+			},
+		},
+		{
+			name: "Proxy",
+			yaml: "proxy: {listen: ':9001'}\nmodels:\n  - {model: " + model + ", alias: a, port: 9001}\n  - {model: " + model + ", port: 9002, proxy: {listen: ':9003'}}\n",
+			wantErrs: []string{
+				"models[0].port: port 9001 is already used by proxy.listen",
+				"models[1].proxy: can only be set at the top level, not for a single model",
+				"models[1].alias: is required to route requests through the proxy",
+			},
+		},
+		{
+			name: "Swap mode",
+			yaml: "proxy: {listen: ':9000', swap: true, idle-ttl: 10m}\nmodels:\n  - {model: " + model + ", alias: a, port: 9001}\n  - {model: " + model + ", alias: b, port: 9001}\n",
+		},
+		{
+			name: "Invalid swap settings",
+			yaml: "proxy: {swap: true, idle-ttl: soon}\nmodels:\n  - {model: " + model + ", alias: a}\n",
+			wantErrs: []string{
+				"proxy.swap: requires proxy.listen",
+				`proxy.idle-ttl: invalid duration "soon", e.g. 30s or 10m`,
+			},
+		},
+		{
+			name:     "Proxy without models",
+			yaml:     "model: " + model + "\nproxy: {listen: 'localhost'}\n",
+			wantErrs: []string{"proxy: can only be used with a models list"},
+		},
+		{
+			name: "Invalid exit policy",
+			yaml: "exit-policy: first-fails\nmodels:\n  - {model: " + model + "}\n",
+			wantErrs: []string{
+				`exit-policy: invalid value "first-fails", must be one of: any-fails, all-fail`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpFile := createTempFile(t, tt.yaml)
+			defer os.Remove(tmpFile)
+
+			config, err := loadConfig(tmpFile)
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			var got []string
+			if err := validateConfig(config); err != nil {
+				got = strings.Split(err.Error(), "\n")
+			}
+			if !reflect.DeepEqual(got, tt.wantErrs) {
+				t.Errorf("validateConfig() = %q, want %q", got, tt.wantErrs)
+			}
+		})
+	}
+}
+
+// TestPrefixWriter tests prefixing each line of a server's output
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	w := newOutputWriter(&buf, OutputConfig{}, "a", "stdout", &mu)
+
+	w.Write([]byte("first line\nsecond "))
+	w.Write([]byte("line\n\nincomplete"))
+	if got, want := buf.String(), "[a] first line\n[a] second line\n[a] \n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	w.Flush()
+	if got, want := buf.String(), "[a] first line\n[a] second line\n[a] \n[a] incomplete\n"; got != want {
+		t.Errorf("output after Flush = %q, want %q", got, want)
+	}
+}
+
+// multiModelStub is a stub llama-server that, after a moment, fails with
+// status 3 for an alias starting with "fail" or exits successfully for the
+// alias "done", and otherwise runs until it is sent SIGTERM.
+const multiModelStub = `
+case "$*" in
+*"--alias fail"*) sleep 0.2; echo "failing"; exit 3 ;;
+*"--alias done"*) sleep 0.2; echo "done"; exit 0 ;;
+esac
+trap 'echo "stopped"; exit 0' TERM
+echo "started"
+while :; do sleep 0.05; done
+`
+
+// TestSupervisor tests running several servers under each exit policy
+func TestSupervisor(t *testing.T) {
+	t.Setenv("PATH", createStubServer(t, multiModelStub)+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tests := []struct {
+		name    string
+		policy  string
+		aliases []string
+		want    int
+		wantOut []string
+	}{
+		{
+			name:    "Any fails stops the others",
+			policy:  exitPolicyAnyFails,
+			aliases: []string{"fail", "run"},
+			want:    3,
+			wantOut: []string{"[fail] failing", "[run] started", "[run] stopped"},
+		},
+		{
+			name:    "Default policy is any fails",
+			aliases: []string{"run", "fail"},
+			want:    3,
+			wantOut: []string{"[fail] failing", "[run] stopped"},
+		},
+		{
+			name:    "All fail with one success",
+			policy:  exitPolicyAllFail,
+			aliases: []string{"fail", "done"},
+			want:    0,
+			wantOut: []string{"[fail] failing", "[done] done"},
+		},
+		{
+			name:    "All fail with every server failing",
+			policy:  exitPolicyAllFail,
+			aliases: []string{"fail", "fail-too"},
+			want:    3,
+			wantOut: []string{"[fail] failing", "[fail-too] failing"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var servers []server
+			for _, alias := range tt.aliases {
+				servers = append(servers, server{name: alias, args: []string{"--alias", alias}})
+			}
+			out := captureStdout(t, func() {
+				s := newSupervisor(&LlamaConfig{ExitPolicy: tt.policy}, servers)
+				if got := s.run(); got != tt.want {
+					t.Errorf("run() = %d, want %d", got, tt.want)
+				}
+			})
+			for _, line := range tt.wantOut {
+				if !strings.Contains(out, line+"\n") {
+					t.Errorf("output does not contain %q:\n%s", line, out)
+				}
+			}
+		})
+	}
+}
+
+// TestSupervisorSignals tests forwarding a signal to every server
+func TestSupervisorSignals(t *testing.T) {
+	t.Setenv("PATH", createStubServer(t, multiModelStub)+string(os.PathListSeparator)+os.Getenv("PATH"))
+	servers := []server{
+		{name: "a", args: []string{"--alias", "a"}},
+		{name: "b", args: []string{"--alias", "b"}},
+	}
+
+	out := captureStdout(t, func() {
+		s := newSupervisor(&LlamaConfig{}, servers)
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+		}()
+
+		done := make(chan int)
+		go func() { done <- s.run() }()
+		select {
+		case code := <-done:
+			if code != 0 {
+				t.Errorf("run() = %d, want 0", code)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("run() did not return after SIGTERM")
+		}
+	})
+	for _, line := range []string{"[a] stopped", "[b] stopped"} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("output does not contain %q:\n%s", line, out)
+		}
+	}
+}
+
+// TestMultiModelDryRun tests printing the command of every model
+func TestMultiModelDryRun(t *testing.T) {
+	model := createTempModel(t)
+	dir := writeConfigFiles(t, map[string]string{
+		"models.yaml": "n-gpu-layers: 99\nmodels:\n  - {model: " + model + ", alias: a, port: 9001}\n  - {model: " + model + ", alias: b, port: 9002}\n",
+	})
+	cfgFile := filepath.Join(dir, "models.yaml")
+
+	var stdout, stderr strings.Builder
+	if code := runPrintArgs([]string{"--config", cfgFile, "--format", "shell"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("runPrintArgs() = %d, stderr: %s", code, stderr.String())
+	}
+	want := "# a\nllama-server --port 9001 --model " + shellQuote(model) + " --alias a --n-gpu-layers 99\n" +
+		"# b\nllama-server --port 9002 --model " + shellQuote(model) + " --alias b --n-gpu-layers 99\n"
+	if stdout.String() != want {
+		t.Errorf("runPrintArgs() output = %q, want %q", stdout.String(), want)
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if code := runPrintArgs([]string{"--config", cfgFile, "--format", "compose"}, &stdout, &stderr); code != 2 {
+		t.Errorf("runPrintArgs() with compose format = %d, want 2", code)
+	}
+}
+
+// captureStdout runs f with os.Stdout redirected, and returns what it wrote,
+// interleaved with llauncher's log at debug level, without timestamps.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	origStdout := os.Stdout
+	os.Stdout = w
+	origLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})))
+	defer slog.SetDefault(origLogger)
+
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		buf.ReadFrom(r)
+		close(done)
+	}()
+
+	f()
+	os.Stdout = origStdout
+	w.Close()
+	<-done
+	return buf.String()
+}
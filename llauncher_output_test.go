@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestClassifyLine tests recognising llama-server's log lines
+func TestClassifyLine(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want outputEvent
+	}{
+		{line: "main: HTTP server is listening, hostname: 127.0.0.1, port: 8080, http threads: 15", want: outputNone},
+		{line: "main: loading model", want: outputLoading},
+		{line: "srv    load_model: loading model '/models/model.gguf'", want: outputLoading},
+		{line: "llama_model_loader: loaded meta data with 35 key-value pairs and 292 tensors", want: outputNone},
+		{line: "main: model loaded", want: outputLoaded},
+		{line: "main: server is listening on http://127.0.0.1:8080 - starting the main loop", want: outputListening},
+		{line: "srv    send_error: task id = 0, error: the request exceeds the available context size", want: outputSlotError},
+		{line: "slot update_slots: id  0 | task 0 | error: failed to decode the batch", want: outputSlotError},
+		{line: "slot release: id  0 | task 0 | stop processing: n_past = 42, truncated = 0", want: outputNone},
+		{line: "ggml_backend_cuda_buffer_type_alloc_buffer: allocating 8192.00 MiB on device 0: cudaMalloc failed: out of memory", want: outputOutOfMemory},
+		{line: "llama_model_load: error loading model: failed to allocate CUDA0 buffer", want: outputOutOfMemory},
+		{line: "terminate called after throwing an instance of 'std::bad_alloc'", want: outputOutOfMemory},
+	} {
+		if got := classifyLine(tt.line); got != tt.want {
+			t.Errorf("classifyLine(%q) = %d, want %d", tt.line, got, tt.want)
+		}
+	}
+}
+
+// TestOutputWriter tests timestamping lines and re-emitting them as JSON, and
+// passing on the events found in them
+func TestOutputWriter(t *testing.T) {
+	var mu sync.Mutex
+
+	var buf bytes.Buffer
+	w := newOutputWriter(&buf, OutputConfig{Timestamps: true}, "a", "stderr", &mu)
+	w.Write([]byte("first line\n"))
+	if re := regexp.MustCompile(`^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}\S* \[a\] first line\n$`); !re.MatchString(buf.String()) {
+		t.Errorf("output = %q, want a timestamp and prefix", buf.String())
+	}
+
+	buf.Reset()
+	var events []outputEvent
+	w = newOutputWriter(&buf, OutputConfig{Format: outputJSON}, "a", "stderr", &mu)
+	w.onEvent = func(event outputEvent, line string) { events = append(events, event) }
+	w.Write([]byte("main: model loaded\nsrv    send_error: task id = 0, error: failed\n"))
+	var records []outputRecord
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record outputRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("output line is not JSON: %q", line)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 || records[0].Msg != "main: model loaded" || records[0].Level != "INFO" || records[1].Level != "ERROR" {
+		t.Errorf("records = %+v, want the lines at INFO and ERROR", records)
+	}
+	if r := records[0]; r.Event != "output" || r.Model != "a" || r.Stream != "stderr" || r.Time == "" {
+		t.Errorf("record = %+v, want event output, model a, stream stderr and a time", r)
+	}
+	if len(events) != 2 || events[0] != outputLoaded || events[1] != outputSlotError {
+		t.Errorf("events = %v, want loaded and slot error", events)
+	}
+}
+
+// loadingStub is a stub llama-server that logs loading its model like
+// llama-server, then runs until it is sent SIGTERM.
+const loadingStub = `
+trap 'exit 0' TERM
+echo "main: loading model"
+sleep 0.3
+echo "cudaMalloc failed: out of memory"
+echo "srv    send_error: task id = 0, error: failed"
+echo "main: model loaded"
+while :; do sleep 0.05; done
+`
+
+// TestOutputReadiness tests tracking a server's readiness and errors from its
+// output, without health checks
+func TestOutputReadiness(t *testing.T) {
+	t.Setenv("PATH", createStubServer(t, loadingStub)+string(os.PathListSeparator)+os.Getenv("PATH"))
+	servers := []server{{config: &LlamaConfig{Alias: "a"}}}
+
+	var states []string
+	var metrics bytes.Buffer
+	out := captureStdout(t, func() {
+		sup := newSupervisor(&LlamaConfig{Output: OutputConfig{Prefix: true}}, servers)
+		done := make(chan int)
+		go func() { done <- sup.run() }()
+		defer func() {
+			syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+			<-done
+		}()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			st := sup.status()
+			if n := len(states); n == 0 || states[n-1] != st.Servers[0].State {
+				states = append(states, st.Servers[0].State)
+			}
+			if st.Ready {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		writeMetrics(&metrics, sup.status())
+	})
+
+	if got := strings.Join(states, " "); !strings.HasSuffix(got, "loading ready") {
+		t.Errorf("states = %q, want loading, then ready:\n%s", got, out)
+	}
+	for _, line := range []string{
+		"[a] main: loading model\n",
+		`msg="llama-server is loading the model" event=health`,
+		`level=ERROR msg="llama-server ran out of memory" event=oom`,
+		`msg="llama-server is ready" event=health`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("output does not contain %q:\n%s", line, out)
+		}
+	}
+	for _, line := range []string{
+		"llauncher_server_ready 1\n",
+		"llauncher_server_slot_errors_total 1\n",
+		"llauncher_server_out_of_memory_errors_total 1\n",
+		"# TYPE llauncher_server_restarts_total counter\nllauncher_server_restarts_total 0\n",
+	} {
+		if !strings.Contains(metrics.String(), line) {
+			t.Errorf("metrics do not contain %q:\n%s", line, metrics.String())
+		}
+	}
+}
+
+// TestValidateOutput tests the checks of the output settings
+func TestValidateOutput(t *testing.T) {
+	if errs := validateOutput(OutputConfig{Format: "json"}); errs != nil {
+		t.Errorf("validateOutput(json) = %v, want nil", errs)
+	}
+	want := `output.format: invalid value "xml", must be one of: text, json`
+	if errs := validateOutput(OutputConfig{Format: "xml"}); errs.Error() != want {
+		t.Errorf("validateOutput(xml) = %v, want %q", errs, want)
+	}
+}
+
+// TestOutputBlocked tests that output blocked on a slow reader does not hold
+// up the status of the servers
+func TestOutputBlocked(t *testing.T) {
+	sup := newSupervisor(&LlamaConfig{}, []server{{config: &LlamaConfig{}}})
+	if w := sup.children[0].stdout.(*outputWriter); w.mu != &sup.outputMu {
+		t.Fatal("the output writer does not use the supervisor's output mutex")
+	}
+
+	sup.outputMu.Lock()
+	defer sup.outputMu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		sup.status()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("status() waited for the output")
+	}
+}
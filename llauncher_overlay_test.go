@@ -0,0 +1,280 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfigFiles writes named configuration files into a temporary
+// directory and returns the directory.
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+// TestConfigOverlays tests merging several configuration files in order
+func TestConfigOverlays(t *testing.T) {
+	base := `
+model: /models/a.gguf
+n-gpu-layers: 99
+ctx-size: 32768
+lora: [/loras/a.gguf]
+extra-args:
+  some-flag: true
+  some-option: 1
+`
+
+	tests := []struct {
+		name     string
+		files    map[string]string
+		load     []string
+		wantArgs []string
+		wantErr  string
+	}{
+		{
+			name:     "Single file",
+			files:    map[string]string{"base.yaml": base},
+			load:     []string{"base.yaml"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--ctx-size", "32768", "--lora", "/loras/a.gguf", "--some-flag", "--some-option", "1"},
+		},
+		{
+			name: "Later file wins",
+			files: map[string]string{
+				"base.yaml": base,
+				"host.yaml": "n-gpu-layers: 40\ntensor-split: 3,1\n",
+			},
+			load:     []string{"base.yaml", "host.yaml"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "40", "--tensor-split", "3,1", "--ctx-size", "32768", "--lora", "/loras/a.gguf", "--some-flag", "--some-option", "1"},
+		},
+		{
+			name: "Nested mappings are merged",
+			files: map[string]string{
+				"base.yaml": base,
+				"host.yaml": "extra-args:\n  some-option: 2\n",
+			},
+			load:     []string{"base.yaml", "host.yaml"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--ctx-size", "32768", "--lora", "/loras/a.gguf", "--some-flag", "--some-option", "2"},
+		},
+		{
+			name: "Lists are replaced",
+			files: map[string]string{
+				"base.yaml": base,
+				"host.yaml": "lora: [/loras/b.gguf]\n",
+			},
+			load:     []string{"base.yaml", "host.yaml"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--ctx-size", "32768", "--lora", "/loras/b.gguf", "--some-flag", "--some-option", "1"},
+		},
+		{
+			name: "Lists tagged append are appended",
+			files: map[string]string{
+				"base.yaml": base,
+				"host.yaml": "lora: !append [/loras/b.gguf]\n",
+			},
+			load:     []string{"base.yaml", "host.yaml"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--ctx-size", "32768", "--lora", "/loras/a.gguf", "--lora", "/loras/b.gguf", "--some-flag", "--some-option", "1"},
+		},
+		{
+			name: "Null unsets a key",
+			files: map[string]string{
+				"base.yaml": base,
+				"host.yaml": "ctx-size: null\n",
+			},
+			load:     []string{"base.yaml", "host.yaml"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--lora", "/loras/a.gguf", "--some-flag", "--some-option", "1"},
+		},
+		{
+			name: "Extends relative to the file",
+			files: map[string]string{
+				"models/base.yaml": base,
+				"hosts/gpu1.yaml":  "extends: ../models/base.yaml\nn-gpu-layers: 40\n",
+			},
+			load:     []string{"hosts/gpu1.yaml"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "40", "--ctx-size", "32768", "--lora", "/loras/a.gguf", "--some-flag", "--some-option", "1"},
+		},
+		{
+			name: "Extends a list in order",
+			files: map[string]string{
+				"base.yaml":  base,
+				"large.yaml": "ctx-size: 131072\nn-gpu-layers: 80\n",
+				"host.yaml":  "extends: [base.yaml, large.yaml]\nn-gpu-layers: 40\n",
+			},
+			load:     []string{"host.yaml"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "40", "--ctx-size", "131072", "--lora", "/loras/a.gguf", "--some-flag", "--some-option", "1"},
+		},
+		{
+			name: "Extends a shared base once",
+			files: map[string]string{
+				"base.yaml":  base,
+				"large.yaml": "extends: base.yaml\nctx-size: 131072\n",
+				"gpu.yaml":   "extends: base.yaml\nn-gpu-layers: 40\n",
+				"host.yaml":  "extends: [large.yaml, gpu.yaml]\n",
+			},
+			load:     []string{"host.yaml"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "40", "--ctx-size", "131072", "--lora", "/loras/a.gguf", "--some-flag", "--some-option", "1"},
+		},
+		{
+			name: "Extends cycle",
+			files: map[string]string{
+				"a.yaml": "extends: b.yaml\n",
+				"b.yaml": "extends: a.yaml\n",
+			},
+			load:    []string{"a.yaml"},
+			wantErr: "configuration files extend each other in a cycle",
+		},
+		{
+			name: "Extends a missing file",
+			files: map[string]string{
+				"host.yaml": "extends: missing.yaml\n",
+			},
+			load:    []string{"host.yaml"},
+			wantErr: "could not read yaml file",
+		},
+		{
+			name: "Unknown key in overlay",
+			files: map[string]string{
+				"base.yaml": base,
+				"host.yaml": "n-gpu-layer: 40\n",
+			},
+			load:    []string{"base.yaml", "host.yaml"},
+			wantErr: `host.yaml:1:1: n-gpu-layer: unknown key, did you mean "n-gpu-layers"?`,
+		},
+		{
+			name: "Not a mapping",
+			files: map[string]string{
+				"host.yaml": "- n-gpu-layers\n",
+			},
+			load:    []string{"host.yaml"},
+			wantErr: "configuration must be a mapping of option names to values",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeConfigFiles(t, tt.files)
+			var paths []string
+			for _, name := range tt.load {
+				paths = append(paths, filepath.Join(dir, name))
+			}
+
+			config, err := loadConfigFiles(paths, loadOptions{Strict: true})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadConfigFiles() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfigFiles() error = %v", err)
+			}
+
+			args, err := buildArgs(config)
+			if err != nil {
+				t.Fatalf("buildArgs() error = %v", err)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("buildArgs() = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+// TestConfigSources tests recording the file each final value came from
+func TestConfigSources(t *testing.T) {
+	t.Setenv("LLAUNCHER_TEST_API_KEY", "sk-secret")
+	dir := writeConfigFiles(t, map[string]string{
+		"base.yaml": "model: /models/a.gguf\nn-gpu-layers: 99\nlora: [/loras/a.gguf]\n",
+		"host.yaml": "extends: base.yaml\n\nn-gpu-layers: 40\nlora: !append [/loras/b.gguf]\napi-key: ${LLAUNCHER_TEST_API_KEY}\n",
+	})
+	base := filepath.Join(dir, "base.yaml")
+	host := filepath.Join(dir, "host.yaml")
+
+	config, err := loadConfigFiles([]string{host}, loadOptions{Strict: true})
+	if err != nil {
+		t.Fatalf("loadConfigFiles() error = %v", err)
+	}
+
+	want := []configSource{
+		{Key: "model", Value: "/models/a.gguf", Origin: base + ":1"},
+		{Key: "n-gpu-layers", Value: "40", Origin: host + ":3"},
+		{Key: "lora[0]", Value: "/loras/a.gguf", Origin: base + ":3"},
+		{Key: "lora[1]", Value: "/loras/b.gguf", Origin: host + ":4"},
+		{Key: "api-key", Value: "sk-secret", Origin: host + ":5", Secret: true},
+	}
+	if !reflect.DeepEqual(config.sources, want) {
+		t.Errorf("sources = %+v, want %+v", config.sources, want)
+	}
+}
+
+// TestConfigSourcesSecrets tests that nested secret values, set for a single
+// model or inherited from a profile, are redacted
+func TestConfigSourcesSecrets(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"models.yaml": `
+models:
+  - {model: /models/a.gguf, alias: a, api-key: sk-model-secret}
+  - {model: /models/b.gguf, alias: b}
+profiles:
+  secure:
+    api-key: sk-profile-secret
+`,
+	})
+	config, err := loadConfigFiles([]string{filepath.Join(dir, "models.yaml")}, loadOptions{Strict: true, Profile: "secure"})
+	if err != nil {
+		t.Fatalf("loadConfigFiles() error = %v", err)
+	}
+
+	want := map[string]string{"models[0].api-key": "sk-model-secret", "models[1].api-key": "sk-profile-secret"}
+	for _, src := range config.sources {
+		if value, ok := want[src.Key]; ok {
+			if src.Value != value || !src.Secret {
+				t.Errorf("source %+v, want %s secret", src, value)
+			}
+			delete(want, src.Key)
+		}
+	}
+	if len(want) > 0 {
+		t.Errorf("sources = %+v, missing %v", config.sources, want)
+	}
+}
+
+// TestMultipleConfigFlags tests merging the files given by repeated --config flags
+func TestMultipleConfigFlags(t *testing.T) {
+	model := createTempModel(t)
+	dir := writeConfigFiles(t, map[string]string{
+		"base.yaml": "model: " + model + "\nn-gpu-layers: 99\n",
+		"host.yaml": "n-gpu-layers: 40\n",
+	})
+	base := filepath.Join(dir, "base.yaml")
+	host := filepath.Join(dir, "host.yaml")
+
+	opts, err := parseOptions([]string{"--config", base, "--config", host}, io.Discard)
+	if err != nil {
+		t.Fatalf("parseOptions() error = %v", err)
+	}
+	if got, err := resolveConfigPaths(opts.configFiles); err != nil || !reflect.DeepEqual(got, []string{base, host}) {
+		t.Errorf("resolveConfigPaths() = %v, %v, want %v", got, err, []string{base, host})
+	}
+
+	var stdout, stderr strings.Builder
+	code := runPrintArgs([]string{"--config", base, "--config", host, "--format", "shell"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("runPrintArgs() = %d, stderr: %s", code, stderr.String())
+	}
+	if want := "llama-server --model " + shellQuote(model) + " --n-gpu-layers 40\n"; stdout.String() != want {
+		t.Errorf("runPrintArgs() output = %q, want %q", stdout.String(), want)
+	}
+}
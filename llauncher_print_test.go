@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestShellQuote tests that quoted arguments are read back unchanged by a shell
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  string
+		want string
+	}{
+		{name: "Plain", arg: "/path/to/model.gguf", want: "/path/to/model.gguf"},
+		{name: "Empty", arg: "", want: "''"},
+		{name: "Spaces", arg: "/path with spaces/model.gguf", want: `"/path with spaces/model.gguf"`},
+		{name: "Double quotes", arg: `{"reasoning_effort": "high"}`, want: `'{"reasoning_effort": "high"}'`},
+		{name: "Single quotes", arg: "it's", want: `'it'\''s'`},
+		{name: "Dollar", arg: "$HOME", want: "'$HOME'"},
+		{name: "Backtick", arg: "a`b", want: "'a`b'"},
+		{name: "Glob", arg: "*.gguf", want: "'*.gguf'"},
+		{name: "Brackets", arg: "model[0].gguf", want: "'model[0].gguf'"},
+		{name: "Glob with spaces", arg: "/path with spaces/*.gguf", want: `"/path with spaces/*.gguf"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := shellQuote(tt.arg)
+			if got != tt.want {
+				t.Errorf("shellQuote(%q) = %s, want %s", tt.arg, got, tt.want)
+			}
+
+			// The shell must read the quoted form back as the original argument.
+			out, err := exec.Command("sh", "-c", "printf '%s' "+got).Output()
+			if err != nil {
+				t.Fatalf("sh failed: %v", err)
+			}
+			if string(out) != tt.arg {
+				t.Errorf("sh read back %q, want %q", out, tt.arg)
+			}
+		})
+	}
+}
+
+// TestFormatCommand tests the print-args output formats
+func TestFormatCommand(t *testing.T) {
+	argv := []string{"llama-server", "--model", "/models/a b.gguf", "--chat-template-kwargs", `{"x": "$y"}`, "--jinja"}
+
+	tests := []struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{
+			format: "pretty",
+			want:   "llama-server --model \"/models/a b.gguf\" \\\n    --chat-template-kwargs '{\"x\": \"$y\"}' \\\n    --jinja\n",
+		},
+		{
+			format: "shell",
+			want:   "llama-server --model \"/models/a b.gguf\" --chat-template-kwargs '{\"x\": \"$y\"}' --jinja\n",
+		},
+		{
+			format: "json",
+			want:   `["llama-server","--model","/models/a b.gguf","--chat-template-kwargs","{\"x\": \"$y\"}","--jinja"]` + "\n",
+		},
+		{
+			format: "dockerfile",
+			want:   `CMD ["--model","/models/a b.gguf","--chat-template-kwargs","{\"x\": \"$y\"}","--jinja"]` + "\n",
+		},
+		{
+			format: "compose",
+			want: "command:\n" +
+				"  - --model\n" +
+				"  - /models/a b.gguf\n" +
+				"  - --chat-template-kwargs\n" +
+				"  - '{\"x\": \"$y\"}'\n" +
+				"  - --jinja\n",
+		},
+		{
+			format:  "xml",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			got, err := formatCommand(argv, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("formatCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("formatCommand() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPrintArgsCommand tests that print-args prints the command without
+// running llama-server
+func TestPrintArgsCommand(t *testing.T) {
+	origExecCommand := execCommand
+	defer func() { execCommand = origExecCommand }()
+	execCommand = func(string, ...string) *exec.Cmd {
+		t.Fatal("print-args must not run llama-server")
+		return nil
+	}
+
+	model := createTempModel(t)
+	cfgFile := createTempFile(t, "model: "+model+"\nport: 8080\njinja: true\n")
+	defer os.Remove(cfgFile)
+
+	var stdout, stderr bytes.Buffer
+	code := runPrintArgs([]string{"--config", cfgFile, "--format", "shell", "--", "--new-flag"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("runPrintArgs() = %d, want 0; stderr: %s", code, stderr.String())
+	}
+	want := "llama-server --port 8080 --model " + model + " --jinja --new-flag\n"
+	if stdout.String() != want {
+		t.Errorf("output = %q, want %q", stdout.String(), want)
+	}
+
+	// An invalid configuration is reported and nothing is printed.
+	badFile := createTempFile(t, "port: 0\n")
+	defer os.Remove(badFile)
+	stdout.Reset()
+	stderr.Reset()
+	if code := runPrintArgs([]string{"--config", badFile}, &stdout, &stderr); code != 1 {
+		t.Fatalf("runPrintArgs() = %d, want 1", code)
+	}
+	if stdout.Len() != 0 || !strings.Contains(stderr.String(), "port: invalid port 0") {
+		t.Errorf("stdout = %q, stderr = %q", stdout.String(), stderr.String())
+	}
+
+	// Arguments for llama-server must follow --, so a flag after a stray
+	// argument is not passed on by mistake.
+	stdout.Reset()
+	stderr.Reset()
+	if code := runPrintArgs([]string{"--config", cfgFile, "stray", "--format", "json"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("runPrintArgs() = %d, want 2", code)
+	}
+	if want := `unexpected argument "stray"; use -- to pass arguments to llama-server`; stdout.Len() != 0 || !strings.Contains(stderr.String(), want) {
+		t.Errorf("stdout = %q, stderr = %q, want %q", stdout.String(), stderr.String(), want)
+	}
+
+	// A command for another machine, such as a container, can be printed
+	// without its model being here.
+	remoteFile := createTempFile(t, "model: /models/remote.gguf\n")
+	defer os.Remove(remoteFile)
+	stdout.Reset()
+	stderr.Reset()
+	if code := runPrintArgs([]string{"--config", remoteFile}, &stdout, &stderr); code != 1 {
+		t.Fatalf("runPrintArgs() = %d, want 1", code)
+	}
+	stdout.Reset()
+	stderr.Reset()
+	if code := runPrintArgs([]string{"--config", remoteFile, "--no-file-checks", "--format", "shell"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("runPrintArgs() with --no-file-checks = %d, stderr: %s", code, stderr.String())
+	}
+	if want := "llama-server --model /models/remote.gguf\n"; stdout.String() != want {
+		t.Errorf("output = %q, want %q", stdout.String(), want)
+	}
+}
+
+// TestDryRun tests that --dry-run prints the command instead of running it
+func TestDryRun(t *testing.T) {
+	origExecCommand := execCommand
+	defer func() { execCommand = origExecCommand }()
+	execCommand = func(string, ...string) *exec.Cmd {
+		t.Fatal("--dry-run must not run llama-server")
+		return nil
+	}
+
+	model := createTempModel(t)
+	cfgFile := createTempFile(t, "model: "+model+"\nport: 8080\n")
+	defer os.Remove(cfgFile)
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	os.Args = []string{"llauncher", "--dry-run", "--config", cfgFile}
+
+	r, w, _ := os.Pipe()
+	origStdout := os.Stdout
+	os.Stdout = w
+	code := run()
+	os.Stdout = origStdout
+	w.Close()
+
+	var buf bytes.Buffer
+	buf.ReadFrom(r)
+
+	if code != 0 {
+		t.Fatalf("run() = %d, want 0", code)
+	}
+	want := "llama-server --port 8080 \\\n    --model " + model + "\n"
+	if buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+
+	// The output is the same as print-args'.
+	var stdout, stderr bytes.Buffer
+	if code := runPrintArgs([]string{"--config", cfgFile}, &stdout, &stderr); code != 0 || stdout.String() != buf.String() {
+		t.Errorf("runPrintArgs() = %d, %q, want 0, %q", code, stdout.String(), buf.String())
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestProfiles tests merging a named profile over the shared configuration
+func TestProfiles(t *testing.T) {
+	base := `
+model: /models/a.gguf
+ctx-size: 8192
+lora: [/loras/a.gguf]
+profiles:
+  fast:
+    n-gpu-layers: 99
+    flash-attn: true
+  long-context:
+    ctx-size: 131072
+    lora: !append [/loras/rope.gguf]
+  plain:
+`
+
+	tests := []struct {
+		name     string
+		files    map[string]string
+		load     []string
+		profile  string
+		strict   bool
+		wantArgs []string
+		wantErr  string
+	}{
+		{
+			name:     "No profile",
+			files:    map[string]string{"base.yaml": base},
+			load:     []string{"base.yaml"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--ctx-size", "8192", "--lora", "/loras/a.gguf"},
+		},
+		{
+			name:     "Profile adds keys",
+			files:    map[string]string{"base.yaml": base},
+			load:     []string{"base.yaml"},
+			profile:  "fast",
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--ctx-size", "8192", "--flash-attn", "on", "--lora", "/loras/a.gguf"},
+		},
+		{
+			name:     "Profile overrides and appends",
+			files:    map[string]string{"base.yaml": base},
+			load:     []string{"base.yaml"},
+			profile:  "long-context",
+			wantArgs: []string{"--model", "/models/a.gguf", "--ctx-size", "131072", "--lora", "/loras/a.gguf", "--lora", "/loras/rope.gguf"},
+		},
+		{
+			name:     "Empty profile",
+			files:    map[string]string{"base.yaml": base},
+			load:     []string{"base.yaml"},
+			profile:  "plain",
+			wantArgs: []string{"--model", "/models/a.gguf", "--ctx-size", "8192", "--lora", "/loras/a.gguf"},
+		},
+		{
+			name: "Profiles merged across overlays",
+			files: map[string]string{
+				"base.yaml": base,
+				"host.yaml": "n-gpu-layers: 20\nprofiles:\n  fast:\n    n-gpu-layers: 40\n",
+			},
+			load:     []string{"base.yaml", "host.yaml"},
+			profile:  "fast",
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "40", "--ctx-size", "8192", "--flash-attn", "on", "--lora", "/loras/a.gguf"},
+		},
+		{
+			name:    "Unknown profile",
+			files:   map[string]string{"base.yaml": base},
+			load:    []string{"base.yaml"},
+			profile: "slow",
+			wantErr: `unknown profile "slow"; available profiles: fast, long-context, plain`,
+		},
+		{
+			name:    "No profiles defined",
+			files:   map[string]string{"base.yaml": "model: /models/a.gguf\n"},
+			load:    []string{"base.yaml"},
+			profile: "fast",
+			wantErr: `unknown profile "fast"; no profiles are defined`,
+		},
+		{
+			name:    "Unknown key in profile",
+			files:   map[string]string{"base.yaml": "profiles:\n  fast:\n    n-gpu-layer: 99\n"},
+			load:    []string{"base.yaml"},
+			strict:  true,
+			wantErr: `base.yaml:3:5: profiles.fast.n-gpu-layer: unknown key, did you mean "profiles.fast.n-gpu-layers"?`,
+		},
+		{
+			name:    "Profiles not a mapping",
+			files:   map[string]string{"base.yaml": "profiles: [fast]\n"},
+			load:    []string{"base.yaml"},
+			wantErr: "base.yaml:1:11: profiles: expected a mapping of profile names to options",
+		},
+		{
+			name:    "Profile not a mapping",
+			files:   map[string]string{"base.yaml": "profiles:\n  fast: 99\n"},
+			load:    []string{"base.yaml"},
+			wantErr: "base.yaml:2:9: profiles.fast: expected a mapping of options",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeConfigFiles(t, tt.files)
+			var paths []string
+			for _, name := range tt.load {
+				paths = append(paths, filepath.Join(dir, name))
+			}
+
+			config, err := loadConfigFiles(paths, loadOptions{Strict: tt.strict, Profile: tt.profile})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadConfigFiles() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfigFiles() error = %v", err)
+			}
+
+			args, err := buildArgs(config)
+			if err != nil {
+				t.Fatalf("buildArgs() error = %v", err)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("buildArgs() = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+// TestProfileSelection tests selecting a profile with --profile and
+// LLAUNCHER_PROFILE in print-args
+func TestProfileSelection(t *testing.T) {
+	model := createTempModel(t)
+	dir := writeConfigFiles(t, map[string]string{
+		"model.yaml": "model: " + model + "\nprofiles:\n  fast:\n    n-gpu-layers: 99\n  embedding:\n    embeddings: true\n",
+	})
+	cfgFile := filepath.Join(dir, "model.yaml")
+
+	tests := []struct {
+		name     string
+		env      string
+		args     []string
+		wantCode int
+		wantOut  string
+		wantErr  string
+	}{
+		{
+			name:    "No profile",
+			wantOut: "llama-server --model " + shellQuote(model) + "\n",
+		},
+		{
+			name:    "Profile flag",
+			args:    []string{"--profile", "fast"},
+			wantOut: "llama-server --model " + shellQuote(model) + " --n-gpu-layers 99\n",
+		},
+		{
+			name:    "Profile from environment",
+			env:     "embedding",
+			wantOut: "llama-server --model " + shellQuote(model) + " --embeddings\n",
+		},
+		{
+			name:    "Profile flag overrides environment",
+			env:     "embedding",
+			args:    []string{"--profile", "fast"},
+			wantOut: "llama-server --model " + shellQuote(model) + " --n-gpu-layers 99\n",
+		},
+		{
+			name:     "Unknown profile",
+			args:     []string{"--profile", "slow"},
+			wantCode: 1,
+			wantErr:  `unknown profile "slow"; available profiles: fast, embedding`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(profileEnvVar, tt.env)
+
+			var stdout, stderr strings.Builder
+			args := append([]string{"--config", cfgFile, "--format", "shell"}, tt.args...)
+			code := runPrintArgs(args, &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Fatalf("runPrintArgs() = %d, want %d, stderr: %s", code, tt.wantCode, stderr.String())
+			}
+			if stdout.String() != tt.wantOut {
+				t.Errorf("runPrintArgs() output = %q, want %q", stdout.String(), tt.wantOut)
+			}
+			if !strings.Contains(stderr.String(), tt.wantErr) {
+				t.Errorf("runPrintArgs() stderr = %q, want %q", stderr.String(), tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestValidateProfiles tests that the validate command checks every profile
+func TestValidateProfiles(t *testing.T) {
+	model := createTempModel(t)
+	dir := writeConfigFiles(t, map[string]string{
+		"model.yaml": "model: " + model + "\ncont-batching: true\nprofiles:\n  fast:\n    n-gpu-layers: 99\n  broken:\n    cache-type-k: q7\n    port: 0\n",
+	})
+	cfgFile := filepath.Join(dir, "model.yaml")
+
+	report := validateFile(cfgFile, loadOptions{Strict: true}, true)
+	if report.Valid {
+		t.Fatalf("validateFile() reported the configuration as valid")
+	}
+	var keys []string
+	for _, e := range report.Errors {
+		keys = append(keys, e.Key)
+	}
+	want := []string{"profiles.broken.cache-type-k", "profiles.broken.port"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("validateFile() error keys = %v, want %v", keys, want)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// stubUpstream starts a stub llama-server API for the given alias, and
+// returns the server behind the proxy that reaches it.
+func stubUpstream(t *testing.T, alias string, handler http.HandlerFunc) server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/models", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"object":"list","data":[{"id":%q,"object":"model","owned_by":"llamacpp"}]}`, alias)
+	})
+	mux.HandleFunc("/", handler)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	host, portText, err := net.SplitHostPort(strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to parse stub server address: %v", err)
+	}
+	port, _ := strconv.Atoi(portText)
+	return server{name: alias, config: &LlamaConfig{Alias: alias, Host: host, Port: port}}
+}
+
+// echoHandler answers with the alias of the upstream, and the path and body
+// of the request it received.
+func echoHandler(alias string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s", alias, r.URL.Path, body)
+	}
+}
+
+// TestRoutingProxy tests routing requests to the upstream named by their model
+func TestRoutingProxy(t *testing.T) {
+	proxy := httptest.NewServer(newRoutingProxy([]server{
+		stubUpstream(t, "small", echoHandler("small")),
+		stubUpstream(t, "large", echoHandler("large")),
+	}))
+	defer proxy.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Chat completion",
+			path:       "/v1/chat/completions",
+			body:       `{"model":"large","messages":[]}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `large /v1/chat/completions {"model":"large","messages":[]}`,
+		},
+		{
+			name:       "Embeddings",
+			path:       "/v1/embeddings",
+			body:       `{"model":"small","input":"hello"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `small /v1/embeddings {"model":"small","input":"hello"}`,
+		},
+		{
+			name:       "Unknown model",
+			path:       "/v1/completions",
+			body:       `{"model":"medium","prompt":"hello"}`,
+			wantStatus: http.StatusNotFound,
+			wantBody:   `model "medium" does not exist`,
+		},
+		{
+			name:       "Missing model with several upstreams",
+			path:       "/v1/completions",
+			body:       `{"prompt":"hello"}`,
+			wantStatus: http.StatusNotFound,
+			wantBody:   `model "" does not exist`,
+		},
+		{
+			name:       "Invalid JSON",
+			path:       "/v1/completions",
+			body:       `{"model":`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "request body is not valid JSON",
+		},
+		{
+			name:       "Unknown endpoint",
+			path:       "/v1/audio/speech",
+			body:       `{"model":"small"}`,
+			wantStatus: http.StatusNotFound,
+			wantBody:   "unknown endpoint: POST /v1/audio/speech",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(proxy.URL+tt.path, "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("POST %s error = %v", tt.path, err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			var apiError struct {
+				Error struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if json.Unmarshal(body, &apiError) == nil && apiError.Error.Message != "" {
+				body = []byte(apiError.Error.Message)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("POST %s status = %d, want %d", tt.path, resp.StatusCode, tt.wantStatus)
+			}
+			if !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("POST %s body = %q, want it to contain %q", tt.path, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// TestRoutingProxyBodyErrors tests that only a request body over the limit
+// is reported as too large
+func TestRoutingProxyBodyErrors(t *testing.T) {
+	proxy := newRoutingProxy([]server{stubUpstream(t, "small", echoHandler("small"))})
+
+	tests := []struct {
+		name       string
+		body       io.Reader
+		wantStatus int
+	}{
+		{name: "Too large", body: bytes.NewReader(make([]byte, maxRequestBody+1)), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "Read error", body: iotest.ErrReader(io.ErrUnexpectedEOF), wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/completions", tt.body))
+			if w.Code != tt.wantStatus || !strings.Contains(w.Body.String(), "could not read request body") {
+				t.Errorf("status = %d, body = %q, want %d", w.Code, w.Body.String(), tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestRoutingProxyAPIPrefix tests that requests reach an upstream under its
+// api-prefix, and that a single upstream does not need the model named
+func TestRoutingProxyAPIPrefix(t *testing.T) {
+	srv := stubUpstream(t, "only", echoHandler("only"))
+	srv.config.ApiPrefix = "/llama"
+	proxy := httptest.NewServer(newRoutingProxy([]server{srv}))
+	defer proxy.Close()
+
+	resp, err := http.Post(proxy.URL+"/v1/completions", "application/json", strings.NewReader(`{"prompt":"hello"}`))
+	if err != nil {
+		t.Fatalf("POST error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if want := `only /llama/v1/completions {"prompt":"hello"}`; string(body) != want {
+		t.Errorf("POST body = %q, want %q", body, want)
+	}
+}
+
+// TestRoutingProxyModels tests listing the models of every upstream, including
+// one that is not answering
+func TestRoutingProxyModels(t *testing.T) {
+	down := stubUpstream(t, "down", echoHandler("down"))
+	down.config.Port = freePort(t)
+	proxy := httptest.NewServer(newRoutingProxy([]server{
+		stubUpstream(t, "small", echoHandler("small")),
+		down,
+		stubUpstream(t, "large", echoHandler("large")),
+	}))
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/v1/models")
+	if err != nil {
+		t.Fatalf("GET /v1/models error = %v", err)
+	}
+	defer resp.Body.Close()
+	var list struct {
+		Object string `json:"object"`
+		Data   []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode model list: %v", err)
+	}
+	var ids []string
+	for _, model := range list.Data {
+		ids = append(ids, model.ID)
+	}
+	if want := []string{"small", "down", "large"}; list.Object != "list" || !reflect.DeepEqual(ids, want) {
+		t.Errorf("GET /v1/models = %s %v, want list %v", list.Object, ids, want)
+	}
+}
+
+// TestRoutingProxyStreaming tests that streamed responses reach the client
+// event by event, without being buffered by the proxy
+func TestRoutingProxyStreaming(t *testing.T) {
+	next := make(chan struct{})
+	srv := stubUpstream(t, "stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 1; i <= 2; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-next:
+			case <-time.After(5 * time.Second):
+				return
+			}
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	})
+	proxy := httptest.NewServer(newRoutingProxy([]server{srv}))
+	defer proxy.Close()
+
+	resp, err := http.Post(proxy.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"stream","stream":true}`))
+	if err != nil {
+		t.Fatalf("POST error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Each event must arrive before the upstream is allowed to send the next.
+	events := bufio.NewReader(resp.Body)
+	for _, want := range []string{"data: 1\n", "\n", "data: 2\n", "\n", "data: [DONE]\n"} {
+		line, err := events.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v", err)
+		}
+		if line != want {
+			t.Fatalf("event line = %q, want %q", line, want)
+		}
+		if line == "\n" {
+			next <- struct{}{}
+		}
+	}
+}
+
+// freePort returns a local port that nothing is listening on.
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// orphanStub is a stub llama-server that leaves behind a process which exits
+// after it does, and fails with status 3.
+const orphanStub = `
+sh -c 'sleep 0.3' &
+echo "orphan $!"
+exit 3
+`
+
+// TestReaper tests reaping an orphaned process in init mode, while still
+// reporting llama-server's own exit status
+func TestReaper(t *testing.T) {
+	t.Setenv("PATH", createStubServer(t, orphanStub)+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// The reaper runs until the orphan, which outlives the server, is reaped.
+	var stop func()
+	defer func() {
+		if stop != nil {
+			stop()
+		}
+	}()
+	out := captureStdout(t, func() {
+		sup := newSupervisor(&LlamaConfig{}, []server{{}})
+		var err error
+		if stop, err = startReaper(sup); err != nil {
+			t.Fatalf("startReaper() error = %v", err)
+		}
+		if got := sup.run(); got != 3 {
+			t.Errorf("run() = %d, want 3", got)
+		}
+	})
+	match := regexp.MustCompile(`orphan (\d+)`).FindStringSubmatch(out)
+	if match == nil {
+		t.Fatalf("output does not name the orphan:\n%s", out)
+	}
+
+	// A zombie keeps its /proc entry until it is reaped.
+	stat := fmt.Sprintf("/proc/%s/stat", match[1])
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(stat); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			data, _ := os.ReadFile(stat)
+			t.Fatalf("orphan %s was not reaped: %s", match[1], data)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
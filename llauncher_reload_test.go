@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// reloadStub is a stub llama-server that reports the arguments it was
+// started and stopped with.
+const reloadStub = `
+trap 'echo "stopped $*"; exit 0' TERM
+echo "started $*"
+while :; do sleep 0.05; done
+`
+
+// TestReload tests restarting a server with its new command on SIGHUP, and
+// keeping it when the new configuration is invalid
+func TestReload(t *testing.T) {
+	t.Setenv("PATH", createStubServer(t, reloadStub)+string(os.PathListSeparator)+os.Getenv("PATH"))
+	model := createTempModel(t)
+	configFile := createTempFile(t, "model: "+model+"\nctx-size: 1024\n")
+	defer os.Remove(configFile)
+
+	prepare := func() (*LlamaConfig, []server, error) {
+		return prepareServers([]string{configFile}, loadOptions{Strict: true}, nil, nil)
+	}
+	config, servers, err := prepare()
+	if err != nil {
+		t.Fatalf("prepareServers() error = %v", err)
+	}
+	reload := func(yaml string) {
+		t.Helper()
+		if err := os.WriteFile(configFile, []byte(yaml), 0644); err != nil {
+			t.Fatal(err)
+		}
+		syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+		time.Sleep(400 * time.Millisecond)
+	}
+
+	var hash string
+	out := captureStdout(t, func() {
+		sup := newSupervisor(config, servers)
+		sup.reload = prepare
+		go func() {
+			time.Sleep(300 * time.Millisecond)
+			reload("model: " + model + "\nctx-size: 2048\n")
+			reload("model: " + model + "\nctx-size: 4096\nthreads: lots\n")
+			reload("model: " + model + "\nctx-size: 2048\nalias: unchanged\n")
+			syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+		}()
+		if got := sup.run(); got != 0 {
+			t.Errorf("run() = %d, want 0", got)
+		}
+		hash = sup.status().ConfigHash
+	})
+
+	for _, want := range []string{
+		"started --model " + model + " --ctx-size 1024\n",
+		`msg="Reloading the configuration" event=reload trigger=SIGHUP`,
+		`msg="Restarting llama-server with its new command" event=reload`,
+		"stopped --model " + model + " --ctx-size 1024\n",
+		`msg="llama-server exited successfully" event=exit`,
+		"stopped_by=SIGTERM\n",
+		"started --model " + model + " --ctx-size 2048\n",
+		`level=ERROR msg="Keeping the running configuration" event=reload error=`,
+		"started --model " + model + " --alias unchanged --ctx-size 2048\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "4096") {
+		t.Errorf("the invalid configuration was applied:\n%s", out)
+	}
+	if got := strings.Count(out, "started "); got != 3 {
+		t.Errorf("llama-server was started %d times, want 3:\n%s", got, out)
+	}
+	_, want, _ := prepare()
+	if hash != configHash(want) {
+		t.Errorf("config hash = %s, want the reloaded configuration's %s", hash, configHash(want))
+	}
+}
+
+// TestCheckReload tests rejecting reloads that need llauncher to restart
+func TestCheckReload(t *testing.T) {
+	model := createTempModel(t)
+	load := func(yaml string) (*LlamaConfig, []server) {
+		t.Helper()
+		tmpFile := createTempFile(t, yaml)
+		defer os.Remove(tmpFile)
+		config, servers, err := prepareServers([]string{tmpFile}, loadOptions{Strict: true}, nil, nil)
+		if err != nil {
+			t.Fatalf("prepareServers() error = %v", err)
+		}
+		return config, servers
+	}
+	two := "proxy: {listen: ':9000'}\nmodels:\n  - {model: " + model + ", alias: a, port: 9001}\n  - {model: " + model + ", alias: b, port: 9002}\n"
+	config, servers := load(two)
+	sup := newSupervisor(config, servers)
+
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{
+			name: "Server settings",
+			yaml: "proxy: {listen: ':9000'}\nctx-size: 2048\nmodels:\n  - {model: " + model + ", alias: a, port: 9001}\n  - {model: " + model + ", alias: b, port: 9002}\n",
+		},
+		{
+			name:    "Model added",
+			yaml:    two + "  - {model: " + model + ", alias: c, port: 9003}\n",
+			wantErr: "models cannot be added or removed without restarting llauncher",
+		},
+		{
+			name:    "Model renamed",
+			yaml:    "proxy: {listen: ':9000'}\nmodels:\n  - {model: " + model + ", alias: a, port: 9001}\n  - {model: " + model + ", alias: c, port: 9002}\n",
+			wantErr: "model b cannot be renamed to c without restarting llauncher",
+		},
+		{
+			name:    "Launcher settings",
+			yaml:    "exit-policy: all-fail\n" + two,
+			wantErr: "exit-policy, proxy, status and output cannot change without restarting llauncher",
+		},
+		{
+			name:    "Port behind the proxy",
+			yaml:    "proxy: {listen: ':9000'}\nmodels:\n  - {model: " + model + ", alias: a, port: 9001}\n  - {model: " + model + ", alias: b, port: 9003}\n",
+			wantErr: "model b cannot move to another address while the routing proxy runs",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := sup.checkReload(load(tt.yaml))
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("checkReload() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("checkReload() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
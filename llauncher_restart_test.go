@@ -0,0 +1,192 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestRestartDelay tests the exponential backoff between restarts
+func TestRestartDelay(t *testing.T) {
+	p := newRestartPolicy(RestartConfig{Backoff: "1s", MaxBackoff: "5s"})
+	for _, tt := range []struct {
+		restart int
+		max     time.Duration
+	}{
+		{restart: 1, max: time.Second},
+		{restart: 2, max: 2 * time.Second},
+		{restart: 3, max: 4 * time.Second},
+		{restart: 4, max: 5 * time.Second},
+		{restart: 40, max: 5 * time.Second},
+	} {
+		for range 20 {
+			if got := p.delay(tt.restart); got < tt.max/2 || got > tt.max {
+				t.Errorf("delay(%d) = %s, want between %s and %s", tt.restart, got, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+// TestValidateRestart tests the checks of the restart settings
+func TestValidateRestart(t *testing.T) {
+	model := createTempModel(t)
+	tmpFile := createTempFile(t, "model: "+model+"\nrestart:\n  policy: sometimes\n  max-retries: -1\n  backoff: 1s\n  reset-after: 5\n")
+	defer os.Remove(tmpFile)
+
+	config, err := loadConfig(tmpFile)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	want := []string{
+		`restart.policy: invalid value "sometimes", must be one of: never, on-failure, always`,
+		"restart.max-retries: invalid value -1, must not be negative",
+		`restart.reset-after: invalid duration "5", e.g. 30s or 10m`,
+	}
+	var got []string
+	if err := validateConfig(config); err != nil {
+		got = strings.Split(err.Error(), "\n")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("validateConfig() = %q, want %q", got, want)
+	}
+}
+
+// flakyStub is a stub llama-server that counts its runs in $RUN_COUNT_FILE,
+// and fails with status 3, or is killed by $KILL_SIGNAL if set, until its
+// $SUCCEED_ON_RUN'th run.
+const flakyStub = `
+n=$(cat "$RUN_COUNT_FILE" 2>/dev/null || echo 0)
+n=$((n + 1))
+echo "$n" > "$RUN_COUNT_FILE"
+echo "run $n"
+[ "$n" -ge "${SUCCEED_ON_RUN:-1000}" ] && exit 0
+[ -n "$KILL_SIGNAL" ] && kill -"$KILL_SIGNAL" $$
+exit 3
+`
+
+// TestSupervisorRestart tests restarting a failed server under a restart policy
+func TestSupervisorRestart(t *testing.T) {
+	t.Setenv("PATH", createStubServer(t, flakyStub)+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tests := []struct {
+		name      string
+		restart   RestartConfig
+		succeedOn string
+		kill      string
+		want      int
+		wantRuns  string
+		wantOut   []string
+	}{
+		{
+			name:      "Never",
+			restart:   RestartConfig{},
+			succeedOn: "2",
+			want:      3,
+			wantRuns:  "1",
+		},
+		{
+			name:      "On failure until success",
+			restart:   RestartConfig{Policy: restartOnFailure, Backoff: "10ms"},
+			succeedOn: "3",
+			want:      0,
+			wantRuns:  "3",
+			wantOut: []string{
+				`level=WARN msg="llama-server exited with status: 3, restarting" event=restart`,
+				"exit_code=3 restart=2 delay=",
+			},
+		},
+		{
+			name:     "On failure with max retries",
+			restart:  RestartConfig{Policy: restartOnFailure, MaxRetries: 2, Backoff: "10ms"},
+			want:     3,
+			wantRuns: "3",
+			wantOut: []string{
+				"restart=2 delay=",
+				"max_retries=2\n",
+				`level=ERROR msg="llama-server exited with status: 3, giving up" event=restart`,
+				"exit_code=3 restarts=2\n",
+			},
+		},
+		{
+			name:     "Killed by a signal",
+			restart:  RestartConfig{Policy: restartOnFailure, MaxRetries: 1, Backoff: "10ms"},
+			kill:     "KILL",
+			want:     128 + 9,
+			wantRuns: "2",
+			wantOut: []string{
+				`level=WARN msg="llama-server was killed by signal: killed, restarting" event=restart`,
+				"exit_code=137 signal=SIGKILL restart=1 delay=",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			countFile := filepath.Join(t.TempDir(), "runs")
+			t.Setenv("RUN_COUNT_FILE", countFile)
+			t.Setenv("SUCCEED_ON_RUN", tt.succeedOn)
+			t.Setenv("KILL_SIGNAL", tt.kill)
+
+			servers := []server{{config: &LlamaConfig{Restart: tt.restart}}}
+			out := captureStdout(t, func() {
+				if got := newSupervisor(&LlamaConfig{}, servers).run(); got != tt.want {
+					t.Errorf("run() = %d, want %d", got, tt.want)
+				}
+			})
+			runs, _ := os.ReadFile(countFile)
+			if got := strings.TrimSpace(string(runs)); got != tt.wantRuns {
+				t.Errorf("llama-server ran %s times, want %s:\n%s", got, tt.wantRuns, out)
+			}
+			for _, line := range tt.wantOut {
+				if !strings.Contains(out, line) {
+					t.Errorf("output does not contain %q:\n%s", line, out)
+				}
+			}
+		})
+	}
+}
+
+// TestSupervisorRestartStopped tests that a server stopped by a signal is not
+// restarted, even under the always policy
+func TestSupervisorRestartStopped(t *testing.T) {
+	t.Setenv("PATH", createStubServer(t, multiModelStub)+string(os.PathListSeparator)+os.Getenv("PATH"))
+	servers := []server{{config: &LlamaConfig{Restart: RestartConfig{Policy: restartAlways, Backoff: "10ms"}}}}
+
+	out := captureStdout(t, func() {
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+		}()
+		if got := newSupervisor(&LlamaConfig{}, servers).run(); got != 0 {
+			t.Errorf("run() = %d, want 0", got)
+		}
+	})
+	if strings.Contains(out, "restarting") || strings.Count(out, "started") != 1 {
+		t.Errorf("llama-server was restarted after SIGTERM:\n%s", out)
+	}
+}
+
+// TestHealthyUptime tests that with health checks the uptime that resets the
+// restarts in a row only counts once the server is ready
+func TestHealthyUptime(t *testing.T) {
+	c := &child{startedAt: time.Now().Add(-time.Hour)}
+	if got := c.healthyUptime(); got < time.Hour {
+		t.Errorf("healthyUptime() without health checks = %s, want the time since the start", got)
+	}
+
+	c.health = &healthCheck{}
+	if got := c.healthyUptime(); got != 0 {
+		t.Errorf("healthyUptime() before ready = %s, want 0", got)
+	}
+	c.setState(healthReady)
+	c.readyAt = c.readyAt.Add(-time.Minute)
+	c.setState(healthUnhealthy)
+	c.setState(healthReady)
+	if got := c.healthyUptime(); got < time.Minute || got >= time.Hour {
+		t.Errorf("healthyUptime() once ready = %s, want the time since ready", got)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSetOverrides tests overriding configuration keys with --set
+func TestSetOverrides(t *testing.T) {
+	yaml := `
+model: /models/a.gguf
+n-gpu-layers: 99
+ctx-size: 32768
+lora: [/loras/a.gguf]
+`
+
+	tests := []struct {
+		name     string
+		sets     []string
+		wantArgs []string
+		wantErr  string
+	}{
+		{
+			name:     "No overrides",
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--ctx-size", "32768", "--lora", "/loras/a.gguf"},
+		},
+		{
+			name:     "Set an integer",
+			sets:     []string{"ctx-size=65536"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--ctx-size", "65536", "--lora", "/loras/a.gguf"},
+		},
+		{
+			name:     "Later override wins",
+			sets:     []string{"n-gpu-layers=0", "n-gpu-layers=40"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "40", "--ctx-size", "32768", "--lora", "/loras/a.gguf"},
+		},
+		{
+			name:     "Value containing equals",
+			sets:     []string{"chat-template-kwargs={\"a\"=1}"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--ctx-size", "32768", "--lora", "/loras/a.gguf", "--chat-template-kwargs", "{\"a\"=1}"},
+		},
+		{
+			name:     "Replace a list",
+			sets:     []string{"lora=/loras/b.gguf,/loras/c.gguf"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--ctx-size", "32768", "--lora", "/loras/b.gguf", "--lora", "/loras/c.gguf"},
+		},
+		{
+			name:     "Append to a list",
+			sets:     []string{"lora+=/loras/b.gguf", "extra-args+=--new-flag"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--ctx-size", "32768", "--lora", "/loras/a.gguf", "--lora", "/loras/b.gguf", "--new-flag"},
+		},
+		{
+			name:     "Unset a key",
+			sets:     []string{"ctx-size="},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--lora", "/loras/a.gguf"},
+		},
+		{
+			name:     "Boolean false uses the negated flag",
+			sets:     []string{"cont-batching=false"},
+			wantArgs: []string{"--model", "/models/a.gguf", "--n-gpu-layers", "99", "--ctx-size", "32768", "--lora", "/loras/a.gguf", "--no-cont-batching"},
+		},
+		{
+			name:    "Unknown key",
+			sets:    []string{"ctx_size=65536"},
+			wantErr: `ctx_size: unknown key, did you mean "ctx-size"?`,
+		},
+		{
+			name:    "Missing value",
+			sets:    []string{"ctx-size"},
+			wantErr: "ctx-size: expected key=value or key+=value",
+		},
+		{
+			name:    "Append to a scalar",
+			sets:    []string{"ctx-size+=1"},
+			wantErr: "ctx-size: += can only be used with lists",
+		},
+		{
+			name:    "Invalid values are all reported",
+			sets:    []string{"ctx-size=large", "temp=hot"},
+			wantErr: "ctx-size: invalid integer \"large\"\ntemp: invalid number \"hot\"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpFile := createTempFile(t, yaml)
+			defer os.Remove(tmpFile)
+
+			config, err := loadConfig(tmpFile)
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			err = applySetOverrides(config, tt.sets)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("applySetOverrides() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applySetOverrides() error = %v", err)
+			}
+
+			args, err := buildArgs(config)
+			if err != nil {
+				t.Fatalf("buildArgs() error = %v", err)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("buildArgs() = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+// TestSetOverridePrecedence tests that --set overrides the environment, and
+// is shown by print-args
+func TestSetOverridePrecedence(t *testing.T) {
+	model := createTempModel(t)
+	tmpFile := createTempFile(t, "model: "+model+"\nn-gpu-layers: 99\n")
+	defer os.Remove(tmpFile)
+	t.Setenv("LLAUNCHER_N_GPU_LAYERS", "40")
+
+	var stdout, stderr strings.Builder
+	code := runPrintArgs([]string{"--config", tmpFile, "--set", "n-gpu-layers=20", "--format", "shell"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("runPrintArgs() = %d, stderr: %s", code, stderr.String())
+	}
+	if want := "llama-server --model " + shellQuote(model) + " --n-gpu-layers 20\n"; stdout.String() != want {
+		t.Errorf("runPrintArgs() output = %q, want %q", stdout.String(), want)
+	}
+}
+
+// TestSetOverridesMultiModel tests that keys each model needs its own value
+// for cannot be set for every model
+func TestSetOverridesMultiModel(t *testing.T) {
+	model := createTempModel(t)
+	tmpFile := createTempFile(t, "models:\n  - {model: "+model+", alias: a, port: 9001}\n  - {model: "+model+", alias: b, port: 9002}\n")
+	defer os.Remove(tmpFile)
+
+	if _, _, err := prepareServers([]string{tmpFile}, loadOptions{Strict: true}, []string{"ctx-size=4096"}, nil); err != nil {
+		t.Fatalf("prepareServers() error = %v", err)
+	}
+	for _, set := range []string{"port=9000", "port=", "alias=shared"} {
+		t.Run(set, func(t *testing.T) {
+			_, _, err := prepareServers([]string{tmpFile}, loadOptions{Strict: true}, []string{set}, nil)
+			key, _, _ := strings.Cut(set, "=")
+			if want := key + ": cannot be overridden for every model"; err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("prepareServers() error = %v, want %q", err, want)
+			}
+		})
+	}
+}
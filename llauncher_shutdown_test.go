@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// shutdownStub is a stub llama-server that reports the signal it is stopped
+// with, and ignores SIGTERM if $IGNORE_TERM is set.
+const shutdownStub = `
+trap 'echo "got INT"; exit 0' INT
+if [ -n "$IGNORE_TERM" ]; then
+	trap '' TERM
+else
+	trap 'echo "got TERM"; exit 0' TERM
+fi
+echo "started"
+while :; do sleep 0.05; done
+`
+
+// TestGracefulShutdown tests stopping a server with its stop signal, and
+// killing it when it does not stop in time
+func TestGracefulShutdown(t *testing.T) {
+	t.Setenv("PATH", createStubServer(t, shutdownStub)+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tests := []struct {
+		name       string
+		config     LlamaConfig
+		ignoreTerm string
+		want       int
+		wantOut    []string
+	}{
+		{
+			name:    "SIGTERM",
+			config:  LlamaConfig{ShutdownTimeout: "5s"},
+			want:    0,
+			wantOut: []string{"got TERM\n", `msg="llama-server exited successfully" event=exit`, "stopped_by=SIGTERM\n"},
+		},
+		{
+			name:    "Translated to SIGINT",
+			config:  LlamaConfig{StopSignal: "SIGINT"},
+			want:    0,
+			wantOut: []string{"got INT\n", `msg="llama-server exited successfully" event=exit`, "stopped_by=SIGINT\n"},
+		},
+		{
+			name:       "Killed after the timeout",
+			config:     LlamaConfig{ShutdownTimeout: "300ms"},
+			ignoreTerm: "1",
+			want:       128 + 9,
+			wantOut: []string{
+				`level=WARN msg="llama-server did not stop in time, killing it" event=kill`,
+				"signal=SIGKILL timeout=300ms stop_signal=SIGTERM\n",
+				`msg="llama-server was killed by signal: killed" event=exit`,
+				`exit_code=137 stopped_by="SIGKILL after the 300ms shutdown timeout"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("IGNORE_TERM", tt.ignoreTerm)
+			servers := []server{{config: &tt.config}}
+
+			out := captureStdout(t, func() {
+				go func() {
+					time.Sleep(300 * time.Millisecond)
+					syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+				}()
+				if got := newSupervisor(&LlamaConfig{}, servers).run(); got != tt.want {
+					t.Errorf("run() = %d, want %d", got, tt.want)
+				}
+			})
+			for _, line := range tt.wantOut {
+				if !strings.Contains(out, line) {
+					t.Errorf("output does not contain %q:\n%s", line, out)
+				}
+			}
+		})
+	}
+}
+
+// TestReportExitOnce tests that an exit is reported once, and that the
+// shutdown timeout does not escalate the signal once the process has exited
+func TestReportExitOnce(t *testing.T) {
+	c := &child{running: true, cmd: &exec.Cmd{Process: &os.Process{Pid: 42}}}
+	c.setStoppedBy("SIGTERM")
+	c.running = false
+	if c.escalate(42, "SIGKILL after the 5s shutdown timeout") {
+		t.Error("escalate() = true after the process exited, want false")
+	}
+
+	out := captureStdout(t, func() {
+		sup := newSupervisor(&LlamaConfig{}, nil)
+		sup.reportExit(c, nil)
+		sup.reportExit(c, nil)
+	})
+	if n := strings.Count(out, "event=exit"); n != 1 || !strings.Contains(out, "stopped_by=SIGTERM\n") {
+		t.Errorf("output has %d exit lines, want 1 stopped by SIGTERM:\n%s", n, out)
+	}
+}
+
+// TestSignalExited tests that a child whose process has exited, e.g. while
+// it waits to be restarted, is not signalled
+func TestSignalExited(t *testing.T) {
+	c := &child{cmd: &exec.Cmd{Process: &os.Process{Pid: 42}}}
+	if err := c.signal(syscall.SIGTERM); err != errNotRunning {
+		t.Errorf("signal() = %v, want %v", err, errNotRunning)
+	}
+	if err := newSupervisor(&LlamaConfig{}, nil).terminate(c, syscall.SIGTERM); err != errNotRunning || c.stopStage() != "" {
+		t.Errorf("terminate() = %v, stopped by %q, want %v and nothing", err, c.stopStage(), errNotRunning)
+	}
+
+	// A process that replaced the one asked to stop is not killed either.
+	c.running = true
+	if err := c.signalProcess(41, syscall.SIGKILL); err != errNotRunning {
+		t.Errorf("signalProcess() of an earlier process = %v, want %v", err, errNotRunning)
+	}
+}
+
+// TestParseSignal tests reading signal names
+func TestParseSignal(t *testing.T) {
+	for name, want := range map[string]syscall.Signal{
+		"":        syscall.SIGTERM,
+		"SIGINT":  syscall.SIGINT,
+		"quit":    syscall.SIGQUIT,
+		"SIGUSR1": syscall.SIGUSR1,
+	} {
+		if got := parseSignal(name, syscall.SIGTERM); got != want {
+			t.Errorf("parseSignal(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// TestValidateShutdown tests the checks of the shutdown settings
+func TestValidateShutdown(t *testing.T) {
+	errs := validateShutdown(&LlamaConfig{StopSignal: "SIGSTOP", ShutdownTimeout: "-1s"})
+	want := []string{
+		`shutdown-timeout: invalid duration "-1s", e.g. 30s or 10m`,
+		`stop-signal: invalid value "SIGSTOP", must be one of: SIGHUP, SIGINT, SIGKILL, SIGQUIT, SIGTERM, SIGUSR1, SIGUSR2`,
+	}
+	if got := strings.Split(errs.Error(), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("validateShutdown() = %q, want %q", got, want)
+	}
+}
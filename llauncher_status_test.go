@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestStatusEndpoints tests reporting a supervised server's state through the
+// status endpoints
+func TestStatusEndpoints(t *testing.T) {
+	origExecCommand := execCommand
+	defer func() { execCommand = origExecCommand }()
+
+	tests := []struct {
+		name      string
+		env       []string
+		wantReady bool
+		wantState string
+	}{
+		{name: "Ready", env: []string{"MOCK_SERVE=1"}, wantReady: true, wantState: "ready"},
+		{name: "Loading", env: []string{"MOCK_SERVE=1", "MOCK_HEALTH=loading"}, wantReady: false, wantState: "loading"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			execCommand = mockExecCommandWithEnv(tt.env...)
+			servers := swapServers(t, "a")
+			servers[0].name = ""
+			servers[0].config.Health = HealthConfig{Enabled: true, Interval: "50ms"}
+
+			captureStdout(t, func() {
+				sup := newSupervisor(&LlamaConfig{}, servers)
+				ts := httptest.NewServer(statusHandler(sup))
+				defer ts.Close()
+
+				done := make(chan int)
+				go func() { done <- sup.run() }()
+				defer func() {
+					syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+					<-done
+				}()
+
+				var st launcherStatus
+				deadline := time.Now().Add(5 * time.Second)
+				for time.Now().Before(deadline) {
+					resp, err := http.Get(ts.URL + "/status")
+					if err != nil {
+						t.Fatalf("GET /status error = %v", err)
+					}
+					json.NewDecoder(resp.Body).Decode(&st)
+					resp.Body.Close()
+					if len(st.Servers) == 1 && st.Servers[0].State == tt.wantState {
+						break
+					}
+					time.Sleep(50 * time.Millisecond)
+				}
+
+				if len(st.Servers) != 1 {
+					t.Fatalf("GET /status = %+v, want one server", st)
+				}
+				got := st.Servers[0]
+				if got.State != tt.wantState || got.Ready != tt.wantReady || got.PID == 0 || got.Restarts != 0 || got.LastExitCode != nil {
+					t.Errorf("GET /status server = %+v, want state %s, ready %v and a PID", got, tt.wantState, tt.wantReady)
+				}
+				if st.PID != os.Getpid() || st.Ready != tt.wantReady || st.ConfigHash != configHash(servers) {
+					t.Errorf("GET /status = %+v, want llauncher's PID, ready %v and config hash %s", st, tt.wantReady, configHash(servers))
+				}
+
+				wantReadyz := http.StatusServiceUnavailable
+				if tt.wantReady {
+					wantReadyz = http.StatusOK
+				}
+				for path, want := range map[string]int{"/livez": http.StatusOK, "/readyz": wantReadyz} {
+					resp, err := http.Get(ts.URL + path)
+					if err != nil {
+						t.Fatalf("GET %s error = %v", path, err)
+					}
+					resp.Body.Close()
+					if resp.StatusCode != want {
+						t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, want)
+					}
+				}
+			})
+		})
+	}
+}
+
+// TestChildStatusAfterExit tests the status of a server that has exited
+func TestChildStatusAfterExit(t *testing.T) {
+	c := &child{name: "a", restarts: 2}
+	code := 3
+	c.lastExit = &code
+
+	got := c.status()
+	if got.State != stateStopped || got.Ready || got.PID != 0 || got.Restarts != 2 || got.LastExitCode == nil || *got.LastExitCode != 3 {
+		t.Errorf("status() = %+v, want stopped after exiting with 3 and 2 restarts", got)
+	}
+}
+
+// TestChildStatusWithoutHealth tests that a server without health checks is
+// only ready once its output reports the model loaded
+func TestChildStatusWithoutHealth(t *testing.T) {
+	c := &child{name: "a", running: true, cmd: &exec.Cmd{Process: &os.Process{Pid: 42}}}
+	for _, tt := range []struct {
+		outputState healthState
+		wantState   string
+		wantReady   bool
+	}{
+		{outputState: "", wantState: "starting", wantReady: false},
+		{outputState: healthLoading, wantState: "loading", wantReady: false},
+		{outputState: healthReady, wantState: "ready", wantReady: true},
+	} {
+		c.outputState = tt.outputState
+		if got := c.status(); got.State != tt.wantState || got.Ready != tt.wantReady || got.PID != 42 {
+			t.Errorf("status() with output state %q = %+v, want state %s, ready %v", tt.outputState, got, tt.wantState, tt.wantReady)
+		}
+	}
+}
+
+// TestValidateStatus tests the checks of the status endpoint's address
+func TestValidateStatus(t *testing.T) {
+	model := createTempModel(t)
+	tests := []struct {
+		name     string
+		yaml     string
+		wantErrs []string
+	}{
+		{
+			name: "Valid",
+			yaml: "model: " + model + "\nstatus: {listen: ':9090'}\n",
+		},
+		{
+			name:     "Invalid address",
+			yaml:     "model: " + model + "\nstatus: {listen: '9090'}\n",
+			wantErrs: []string{`status.listen: invalid address "9090", must be host:port or :port`},
+		},
+		{
+			name:     "Same port as the server",
+			yaml:     "model: " + model + "\nstatus: {listen: ':8080'}\n",
+			wantErrs: []string{"status.listen: port 8080 is already used by port"},
+		},
+		{
+			name:     "Same port as a model",
+			yaml:     "status: {listen: ':9002'}\nmodels:\n  - {model: " + model + ", alias: a, port: 9001}\n  - {model: " + model + ", alias: b, port: 9002}\n",
+			wantErrs: []string{"status.listen: port 9002 is already used by models[1].port"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpFile := createTempFile(t, tt.yaml)
+			defer os.Remove(tmpFile)
+
+			config, err := loadConfig(tmpFile)
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			var got []string
+			if err := validateConfig(config); err != nil {
+				got = strings.Split(err.Error(), "\n")
+			}
+			if !reflect.DeepEqual(got, tt.wantErrs) {
+				t.Errorf("validateConfig() = %q, want %q", got, tt.wantErrs)
+			}
+		})
+	}
+}
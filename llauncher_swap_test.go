@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// swapServers returns servers for the given aliases, each on its own free
+// local port.
+func swapServers(t *testing.T, aliases ...string) []server {
+	t.Helper()
+	var servers []server
+	for _, alias := range aliases {
+		port := freePort(t)
+		servers = append(servers, server{
+			name:   alias,
+			config: &LlamaConfig{Alias: alias, Host: "127.0.0.1", Port: port},
+			args:   []string{"--port", strconv.Itoa(port), "--alias", alias},
+		})
+	}
+	return servers
+}
+
+// postModel sends a completion request for a model through the proxy, and
+// returns the status and body of the response.
+func postModel(t *testing.T, url, model string) (int, string) {
+	t.Helper()
+	resp, err := http.Post(url+"/v1/completions", "application/json", strings.NewReader(`{"model":"`+model+`"}`))
+	if err != nil {
+		t.Fatalf("POST for %s error = %v", model, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+// TestSwapMode tests starting servers on demand, one at a time, and stopping
+// them when idle
+func TestSwapMode(t *testing.T) {
+	origExecCommand := execCommand
+	defer func() { execCommand = origExecCommand }()
+	execCommand = mockExecCommandWithEnv("MOCK_SERVE=1")
+
+	servers := swapServers(t, "a", "b")
+	out := captureStdout(t, func() {
+		proxy := newRoutingProxy(servers)
+		proxy.swap = newSwapper(newSupervisor(&LlamaConfig{}, servers), proxy, 500*time.Millisecond)
+		ts := httptest.NewServer(proxy)
+		defer ts.Close()
+
+		// Concurrent requests for the same model share one start.
+		var wg sync.WaitGroup
+		for range 3 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if status, body := postModel(t, ts.URL, "a"); status != http.StatusOK || body != "a /v1/completions" {
+					t.Errorf("POST for a = %d %q, want 200 %q", status, body, "a /v1/completions")
+				}
+			}()
+		}
+		wg.Wait()
+
+		if status, body := postModel(t, ts.URL, "b"); status != http.StatusOK || body != "b /v1/completions" {
+			t.Errorf("POST for b = %d %q, want 200 %q", status, body, "b /v1/completions")
+		}
+
+		time.Sleep(time.Second)
+		if code := proxy.swap.shutdown(syscall.SIGTERM); code != 0 {
+			t.Errorf("shutdown() = %d, want 0", code)
+		}
+	})
+
+	for _, line := range []string{
+		`msg="Started llama-server" model=a event=start`,
+		`msg="Stopping llama-server to load b" model=a event=stop`,
+		`msg="Started llama-server" model=b event=start`,
+		`msg="Stopping llama-server after being idle for 500ms" model=b event=stop`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("output does not contain %q:\n%s", line, out)
+		}
+	}
+	if n := strings.Count(out, `msg="Started llama-server" model=a`); n != 1 {
+		t.Errorf("a was started %d times, want once:\n%s", n, out)
+	}
+}
+
+// TestSwapModeLoadFailure tests that requests waiting for a model are told
+// when its server fails to start
+func TestSwapModeLoadFailure(t *testing.T) {
+	origExecCommand := execCommand
+	defer func() { execCommand = origExecCommand }()
+	execCommand = mockExecCommandWithEnv("MOCK_EXIT_CODE=3")
+
+	servers := swapServers(t, "a")
+	captureStdout(t, func() {
+		proxy := newRoutingProxy(servers)
+		proxy.swap = newSwapper(newSupervisor(&LlamaConfig{}, servers), proxy, 0)
+		ts := httptest.NewServer(proxy)
+		defer ts.Close()
+
+		status, body := postModel(t, ts.URL, "a")
+		if want := `model \"a\" could not be loaded: llama-server exited with status: 3`; status != http.StatusServiceUnavailable || !strings.Contains(body, want) {
+			t.Errorf("POST for a = %d %q, want 503 containing %q", status, body, want)
+		}
+	})
+}
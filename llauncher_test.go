@@ -1,7 +1,11 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"io"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"os/exec"
@@ -147,6 +151,30 @@ func TestBuildArgs(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Explicit false uses the counterpart flag",
+			config: &LlamaConfig{
+				set: map[string]bool{
+					"no-webui": true, "no-perf": true, "no-mmap": true,
+					"no-kv-offload": true, "no-repack": true, "no-op-offload": true,
+					"no-mmproj-offload": true, "jinja": true, "no-prefill-assistant": true,
+					"no-warmup": true, "mlock": true,
+				},
+			},
+			want: []string{
+				"--webui",
+				"--perf",
+				"--mmap",
+				"--kv-offload",
+				"--repack",
+				"--op-offload",
+				"--mmproj-offload",
+				"--no-jinja",
+				"--prefill-assistant",
+				"--warmup",
+			},
+			wantErr: false,
+		},
 		{
 			name: "Config with numeric values",
 			config: &LlamaConfig{
@@ -183,6 +211,113 @@ func TestBuildArgs(t *testing.T) {
 	}
 }
 
+// TestParseOptions tests parsing llauncher's own options and splitting the
+// command line at the "--" separator
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		want     options
+		wantHelp bool
+		wantErr  string
+	}{
+		{
+			name: "No arguments",
+			args: []string{},
+			want: options{},
+		},
+		{
+			name: "No separator",
+			args: []string{"--debug"},
+			want: options{debug: true},
+		},
+		{
+			name: "Separator with arguments",
+			args: []string{"--config", "a.yaml", "--", "--new-flag", "--debug"},
+			want: options{configFiles: []string{"a.yaml"}, passthrough: []string{"--new-flag", "--debug"}},
+		},
+		{
+			name: "Trailing separator",
+			args: []string{"--"},
+			want: options{passthrough: []string{}},
+		},
+		{
+			name: "All options",
+			args: []string{"--config", "a.yaml", "--config=b.yaml", "--profile", "fast", "--no-strict", "--dry-run", "--init", "--reload", "--blue-green", "--log-level", "warn", "--log-format=json", "--set", "ctx-size=65536", "--set=lora+=/a.gguf"},
+			want: options{
+				configFiles: []string{"a.yaml", "b.yaml"},
+				profile:     "fast",
+				noStrict:    true,
+				dryRun:      true,
+				init:        true,
+				reload:      true,
+				blueGreen:   true,
+				logLevel:    "warn",
+				logFormat:   "json",
+				sets:        []string{"ctx-size=65536", "lora+=/a.gguf"},
+			},
+		},
+		{
+			name:     "Help",
+			args:     []string{"--debug", "--help"},
+			wantHelp: true,
+		},
+		{
+			name:    "Unknown flag",
+			args:    []string{"--verbose"},
+			wantErr: "flag provided but not defined: -verbose",
+		},
+		{
+			name:    "Missing value",
+			args:    []string{"--config"},
+			wantErr: "flag needs an argument: -config",
+		},
+		{
+			name:    "Invalid log level",
+			args:    []string{"--log-level", "trace"},
+			wantErr: `invalid --log-level "trace", must be one of: debug, error, info, warn`,
+		},
+		{
+			name:    "Invalid log format",
+			args:    []string{"--log-format", "xml"},
+			wantErr: `invalid --log-format "xml", must be one of: text, json`,
+		},
+		{
+			name:    "Argument without separator",
+			args:    []string{"--debug", "--threads", "4"},
+			wantErr: "flag provided but not defined: -threads",
+		},
+		{
+			name:    "Positional argument",
+			args:    []string{"model.yaml"},
+			wantErr: `unexpected argument "model.yaml"; use -- to pass arguments to llama-server`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOptions(tt.args, io.Discard)
+			switch {
+			case tt.wantHelp:
+				if !errors.Is(err, flag.ErrHelp) {
+					t.Fatalf("parseOptions() error = %v, want flag.ErrHelp", err)
+				}
+				return
+			case tt.wantErr != "":
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseOptions() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatalf("parseOptions() error = %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("parseOptions() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
 // TestFormatArgsForDisplay tests the formatArgsForDisplay function
 func TestFormatArgsForDisplay(t *testing.T) {
 	tests := []struct {
@@ -290,6 +425,17 @@ func createTempFile(t *testing.T, content string) string {
 	return tmpfile.Name()
 }
 
+// Helper function to create an empty model file, for configurations that must
+// pass validation
+func createTempModel(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatalf("Failed to create model file: %v", err)
+	}
+	return path
+}
+
 // Helper function to generate a random string
 func randomString(n int) string {
 	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
@@ -311,7 +457,7 @@ func TestMainHelp(t *testing.T) {
 
 	// Since main() calls os.Exit(), we need to run it in a subprocess.
 	// Run the built llauncher binary with the help flag.
-	cmd := exec.Command("./llauncher", "--help")
+	cmd := exec.Command(buildLlauncher(t), "--help")
 	cmd.Env = os.Environ()
 	// Suppress output; we only care about the exit code.
 	cmd.Stdout = nil
@@ -331,20 +477,25 @@ func TestMainHelp(t *testing.T) {
 
 // TestConfigFileHandling tests how the program handles different config file scenarios
 func TestConfigFileHandling(t *testing.T) {
-	// Create a valid config file
+	// Create a valid config file, with a model file that exists
 	validConfig := `
-model: /path/to/model.gguf
+model: ` + createTempModel(t) + `
 host: 0.0.0.0
 port: 8080
 `
 	validFile := createTempFile(t, validConfig)
 	defer os.Remove(validFile)
 
+	// A stub llama-server on PATH stands in for the real server, so the
+	// subprocess exits with the stub's status.
+	stubPath := "PATH=" + createStubServer(t, "exit 0") + string(os.PathListSeparator) + os.Getenv("PATH")
+
 	// Helper to run main in a subprocess with given args and env.
+	llauncher := buildLlauncher(t)
 	runMain := func(args []string, env []string) error {
-		cmd := exec.Command(os.Args[0], "-test.run=TestConfigFileHandling")
-		cmd.Env = append(os.Environ(), env...)
-		cmd.Args = append([]string{os.Args[0]}, args...)
+		cmd := exec.Command(llauncher)
+		cmd.Env = append(append(os.Environ(), stubPath), env...)
+		cmd.Args = args
 		// Suppress output; we only care about exit code.
 		cmd.Stdout = nil
 		cmd.Stderr = nil
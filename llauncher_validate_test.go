@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestValidateConfig tests the semantic validation of configurations
+func TestValidateConfig(t *testing.T) {
+	model := createTempModel(t)
+
+	tests := []struct {
+		name string
+		yaml string
+		want []configError
+	}{
+		{
+			name: "Valid config",
+			yaml: `
+model: ` + model + `
+port: 8080
+cache-type-k: q8_0
+split-mode: layer
+cont-batching: true
+no-slots: true
+draft-min: 2
+draft-max: 16
+`,
+			want: nil,
+		},
+		{
+			name: "Mutually exclusive options",
+			yaml: `
+model: ` + model + `
+cont-batching: true
+no-cont-batching: true
+escape: true
+no-escape: true
+slots: true
+no-slots: true
+context-shift: true
+no-context-shift: true
+grammar: "root ::= \"yes\""
+grammar-file: ` + model + `
+json-schema: "{}"
+json-schema-file: ` + model + `
+`,
+			want: []configError{
+				{Key: "cont-batching", Message: "cannot be used together with no-cont-batching"},
+				{Key: "escape", Message: "cannot be used together with no-escape"},
+				{Key: "slots", Message: "cannot be used together with no-slots"},
+				{Key: "context-shift", Message: "cannot be used together with no-context-shift"},
+				{Key: "grammar", Message: "cannot be used together with grammar-file"},
+				{Key: "json-schema", Message: "cannot be used together with json-schema-file"},
+			},
+		},
+		{
+			name: "Explicit false also conflicts",
+			yaml: `
+model: ` + model + `
+cont-batching: false
+no-cont-batching: true
+escape: false
+no-escape: false
+slots: false
+no-slots: false
+context-shift: false
+no-context-shift: false
+`,
+			want: []configError{
+				{Key: "cont-batching", Message: "cannot be used together with no-cont-batching"},
+				{Key: "escape", Message: "cannot be used together with no-escape"},
+				{Key: "slots", Message: "cannot be used together with no-slots"},
+				{Key: "context-shift", Message: "cannot be used together with no-context-shift"},
+			},
+		},
+		{
+			name: "Invalid enum values",
+			yaml: `
+model: ` + model + `
+cache-type-k: q9_0
+cache-type-v: f16
+split-mode: column
+numa: everywhere
+rope-scaling: yarn
+pooling: max
+reasoning-format: verbose
+`,
+			want: []configError{
+				{Key: "cache-type-k", Message: `invalid value "q9_0", must be one of: f32, f16, bf16, q8_0, q4_0, q4_1, iq4_nl, q5_0, q5_1`},
+				{Key: "numa", Message: `invalid value "everywhere", must be one of: distribute, isolate, numactl`},
+				{Key: "pooling", Message: `invalid value "max", must be one of: none, mean, cls, last, rank`},
+				{Key: "reasoning-format", Message: `invalid value "verbose", must be one of: none, deepseek, deepseek-legacy, auto`},
+				{Key: "split-mode", Message: `invalid value "column", must be one of: none, layer, row`},
+			},
+		},
+		{
+			name: "Port out of range",
+			yaml: `
+model: ` + model + `
+port: 70000
+`,
+			want: []configError{
+				{Key: "port", Message: "invalid port 70000, must be between 1 and 65535"},
+			},
+		},
+		{
+			name: "Explicit port zero",
+			yaml: `
+model: ` + model + `
+port: 0
+`,
+			want: []configError{
+				{Key: "port", Message: "invalid port 0, must be between 1 and 65535"},
+			},
+		},
+		{
+			name: "Draft min greater than max",
+			yaml: `
+model: ` + model + `
+draft-min: 8
+draft-max: 4
+`,
+			want: []configError{
+				{Key: "draft-min", Message: "8 is greater than draft-max 4"},
+			},
+		},
+		{
+			name: "Draft min greater than explicit zero max",
+			yaml: `
+model: ` + model + `
+draft-min: 5
+draft-max: 0
+`,
+			want: []configError{
+				{Key: "draft-min", Message: "5 is greater than draft-max 0"},
+			},
+		},
+		{
+			name: "Missing files",
+			yaml: `
+model: /nonexistent/model.gguf
+mmproj: /nonexistent/mmproj.gguf
+lora:
+  - ` + model + `
+  - /nonexistent/lora.gguf
+grammar-file: /nonexistent/grammar.gbnf
+`,
+			want: []configError{
+				{Key: "model", Message: `file "/nonexistent/model.gguf" does not exist`},
+				{Key: "mmproj", Message: `file "/nonexistent/mmproj.gguf" does not exist`},
+				{Key: "lora", Message: `file "/nonexistent/lora.gguf" does not exist`},
+				{Key: "grammar-file", Message: `file "/nonexistent/grammar.gbnf" does not exist`},
+			},
+		},
+		{
+			name: "Conflicting model sources",
+			yaml: `
+model-url: https://example.com/model.gguf
+hf-repo: example/model
+`,
+			want: []configError{
+				{Key: "model-url", Message: "only one model source may be set, found model-url, hf-repo"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpfile := createTempFile(t, tt.yaml)
+			defer os.Remove(tmpfile)
+
+			config, err := loadConfig(tmpfile)
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+
+			err = validateConfig(config)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("validateConfig() error = %v, want nil", err)
+				}
+				return
+			}
+
+			var errs configErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("validateConfig() error = %v, want configErrors", err)
+			}
+			if !reflect.DeepEqual([]configError(errs), tt.want) {
+				t.Errorf("validateConfig() errors =\n%v\nwant\n%v", errs, configErrors(tt.want))
+			}
+		})
+	}
+}
+
+// TestValidateCommand tests the validate subcommand's reports and exit codes
+func TestValidateCommand(t *testing.T) {
+	dir := t.TempDir()
+	model := createTempModel(t)
+	good := filepath.Join(dir, "good.yaml")
+	bad := filepath.Join(dir, "bad.yml")
+	if err := os.WriteFile(good, []byte("model: "+model+"\nport: 8080\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bad, []byte("model: "+model+"\nn-gpu-layer: 99\nsplit-mode: column\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a config"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Valid file", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		if code := runValidate([]string{good}, &stdout, &stderr); code != 0 {
+			t.Fatalf("runValidate() = %d, want 0; stderr: %s", code, stderr.String())
+		}
+		if want := good + ": OK\n"; stdout.String() != want {
+			t.Errorf("output = %q, want %q", stdout.String(), want)
+		}
+	})
+
+	t.Run("Directory in text format", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		if code := runValidate([]string{dir}, &stdout, &stderr); code != 1 {
+			t.Fatalf("runValidate() = %d, want 1; stderr: %s", code, stderr.String())
+		}
+		want := bad + ": 1 problem(s)\n" +
+			"  " + bad + `:2:1: n-gpu-layer: unknown key, did you mean "n-gpu-layers"?` + "\n" +
+			good + ": OK\n"
+		if stdout.String() != want {
+			t.Errorf("output =\n%s\nwant\n%s", stdout.String(), want)
+		}
+	})
+
+	t.Run("Missing model without file checks", func(t *testing.T) {
+		missing := filepath.Join(t.TempDir(), "missing.yaml")
+		if err := os.WriteFile(missing, []byte("model: /models/missing.gguf\nmmproj: /models/missing-mmproj.gguf\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var stdout, stderr bytes.Buffer
+		if code := runValidate([]string{missing}, &stdout, &stderr); code != 1 {
+			t.Fatalf("runValidate() = %d, want 1 with file checks; stderr: %s", code, stderr.String())
+		}
+		stdout.Reset()
+		if code := runValidate([]string{"--no-file-checks", missing}, &stdout, &stderr); code != 0 {
+			t.Fatalf("runValidate(--no-file-checks) = %d, want 0; output: %s", code, stdout.String())
+		}
+		if want := missing + ": OK\n"; stdout.String() != want {
+			t.Errorf("output = %q, want %q", stdout.String(), want)
+		}
+	})
+
+	t.Run("Directory in JSON format", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		if code := runValidate([]string{"--format", "json", "--no-strict", dir}, &stdout, &stderr); code != 1 {
+			t.Fatalf("runValidate() = %d, want 1; stderr: %s", code, stderr.String())
+		}
+		var reports []validationReport
+		if err := json.Unmarshal(stdout.Bytes(), &reports); err != nil {
+			t.Fatalf("output is not valid JSON: %v\n%s", err, stdout.String())
+		}
+		want := []validationReport{
+			{
+				Path:  bad,
+				Valid: false,
+				Errors: []configError{
+					{File: bad, Key: "split-mode", Message: `invalid value "column", must be one of: none, layer, row`},
+				},
+			},
+			{Path: good, Valid: true},
+		}
+		if !reflect.DeepEqual(reports, want) {
+			t.Errorf("reports = %+v, want %+v", reports, want)
+		}
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		missing := filepath.Join(dir, "missing.yaml")
+		if code := runValidate([]string{missing}, &stdout, &stderr); code != 1 {
+			t.Fatalf("runValidate() = %d, want 1", code)
+		}
+		if !strings.HasPrefix(stdout.String(), missing+": 1 problem(s)\n") {
+			t.Errorf("output = %q", stdout.String())
+		}
+	})
+
+	t.Run("Unknown format", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		if code := runValidate([]string{"--format", "xml", good}, &stdout, &stderr); code != 2 {
+			t.Fatalf("runValidate() = %d, want 2", code)
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWatchFiles tests noticing a change to a configuration file's contents,
+// including when it is replaced by a rename as editors do
+func TestWatchFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("ctx-size: 1024\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changes, stop, err := watchFiles([]string{path})
+	if err != nil {
+		t.Fatalf("watchFiles() error = %v", err)
+	}
+	defer stop()
+
+	expect := func(want bool) {
+		t.Helper()
+		select {
+		case got := <-changes:
+			if !want {
+				t.Errorf("watchFiles() reported a change to %s, want none", got)
+			} else if got != path {
+				t.Errorf("watchFiles() reported %s, want %s", got, path)
+			}
+		case <-time.After(time.Second):
+			if want {
+				t.Errorf("watchFiles() did not report the change")
+			}
+		}
+	}
+
+	// Writing the same contents, or another file, is not a change.
+	os.WriteFile(path, []byte("ctx-size: 1024\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "other.yaml"), []byte("ctx-size: 2048\n"), 0644)
+	expect(false)
+
+	os.WriteFile(path, []byte("ctx-size: 2048\n"), 0644)
+	expect(true)
+
+	tmp := filepath.Join(dir, ".config.yaml.swp")
+	os.WriteFile(tmp, []byte("ctx-size: 4096\n"), 0644)
+	os.Rename(tmp, path)
+	expect(true)
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -39,9 +42,6 @@ host: 0.0.0.0
 			name: "YAML with nested structures",
 			yaml: `
 model: /path/to/model.gguf
-advanced:
-  option1: value1
-  option2: value2
 lora:
   - adapter1.bin
   - adapter2.bin
@@ -54,6 +54,19 @@ lora:
 					c.LoraAdapters[1] == "adapter2.bin"
 			},
 		},
+		{
+			name: "YAML with unknown nested structures",
+			yaml: `
+model: /path/to/model.gguf
+advanced:
+  option1: value1
+  option2: value2
+`,
+			wantErr: true,
+			validate: func(c *LlamaConfig) bool {
+				return true // Not used when wantErr is true
+			},
+		},
 		{
 			name: "YAML with type mismatches",
 			yaml: `
@@ -286,3 +299,252 @@ slot-save-path: ./slots
 		t.Errorf("SlotSavePath = %v, want %v", config.SlotSavePath, "./slots")
 	}
 }
+
+// TestExplicitZeroValues tests that zero values written in the YAML are
+// passed through to llama-server, while omitted keys are not.
+func TestExplicitZeroValues(t *testing.T) {
+	yaml := `
+model: /path/to/model.gguf
+n-gpu-layers: 0
+seed: 0
+temp: 0
+repeat-penalty: 0
+cont-batching: false
+context-shift: false
+flash-attn: false
+mlock: false
+alias: ""
+top-k:
+`
+
+	tmpfile := createTempFile(t, yaml)
+	defer os.Remove(tmpfile)
+
+	config, err := loadConfig(tmpfile)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+
+	got, err := buildArgs(config)
+	if err != nil {
+		t.Fatalf("buildArgs() error = %v", err)
+	}
+
+	want := []string{
+		"--model", "/path/to/model.gguf",
+		"--n-gpu-layers", "0",
+		"--flash-attn", "off",
+		"--seed", "0",
+		"--temp", "0",
+		"--repeat-penalty", "0",
+		"--no-cont-batching",
+		"--no-context-shift",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildArgs() = %v, want %v", got, want)
+	}
+
+	if config.isSet("top-k") {
+		t.Errorf("isSet(top-k) = true for a null value, want false")
+	}
+	if config.isSet("threads") {
+		t.Errorf("isSet(threads) = true for an omitted key, want false")
+	}
+}
+
+// TestFlashAttn tests that flash-attn takes on, off or auto, and a boolean
+// for on or off
+func TestFlashAttn(t *testing.T) {
+	for _, tt := range []struct {
+		value   string
+		want    []string
+		wantErr string
+	}{
+		{value: "true", want: []string{"--flash-attn", "on"}},
+		{value: "false", want: []string{"--flash-attn", "off"}},
+		{value: "auto", want: []string{"--flash-attn", "auto"}},
+		{value: "Off", want: []string{"--flash-attn", "off"}},
+		{value: "sometimes", wantErr: `flash-attn: invalid value "sometimes", must be one of: on, off, auto`},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			tmpfile := createTempFile(t, "flash-attn: "+tt.value+"\n")
+			defer os.Remove(tmpfile)
+
+			config, err := loadConfig(tmpfile)
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			if err := validateConfig(config); tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("validateConfig() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if got, _ := buildArgs(config); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	config := &LlamaConfig{}
+	if err := applySetOverrides(config, []string{"flash-attn=false"}); err != nil {
+		t.Fatalf("applySetOverrides() error = %v", err)
+	}
+	if config.FlashAttn != "off" {
+		t.Errorf("FlashAttn after --set flash-attn=false = %q, want off", config.FlashAttn)
+	}
+}
+
+// TestExtraArgs tests the rendering of the extra-args section
+func TestExtraArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "Mapping with typed values",
+			yaml: `
+model: /path/to/model.gguf
+extra-args:
+  new-flag: true
+  disabled-flag: false
+  bare-flag:
+  --dashed-option: value
+  -s: 7
+  new-ratio: 0.50
+  new-list: [a, b]
+`,
+			want: []string{
+				"--model", "/path/to/model.gguf",
+				"--new-flag",
+				"--bare-flag",
+				"--dashed-option", "value",
+				"-s", "7",
+				"--new-ratio", "0.5",
+				"--new-list", "a", "--new-list", "b",
+			},
+		},
+		{
+			name: "List of raw arguments",
+			yaml: `
+model: /path/to/model.gguf
+extra-args:
+  - --new-option
+  - "some value"
+  - --new-flag
+`,
+			want: []string{
+				"--model", "/path/to/model.gguf",
+				"--new-option", "some value",
+				"--new-flag",
+			},
+		},
+		{
+			name: "Nested mapping value",
+			yaml: `
+extra-args:
+  new-option:
+    nested: value
+`,
+			wantErr: true,
+		},
+		{
+			name: "Scalar instead of list or mapping",
+			yaml: `
+extra-args: --new-flag
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpfile := createTempFile(t, tt.yaml)
+			defer os.Remove(tmpfile)
+
+			config, err := loadConfig(tmpfile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got, err := buildArgs(config)
+			if err != nil {
+				t.Fatalf("buildArgs() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestStrictConfig tests the reporting of unknown keys in strict mode
+func TestStrictConfig(t *testing.T) {
+	yaml := `
+model: /path/to/model.gguf
+n-gpu-layer: 99
+ctx_size: 32768
+advanced:
+  option1: value1
+`
+	tmpfile := createTempFile(t, yaml)
+	defer os.Remove(tmpfile)
+
+	_, err := loadConfig(tmpfile)
+	var errs configErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("loadConfig() error = %v, want configErrors", err)
+	}
+
+	want := []configError{
+		{File: tmpfile, Line: 3, Column: 1, Key: "n-gpu-layer", Message: `unknown key, did you mean "n-gpu-layers"?`},
+		{File: tmpfile, Line: 4, Column: 1, Key: "ctx_size", Message: `unknown key, did you mean "ctx-size"?`},
+		{File: tmpfile, Line: 5, Column: 1, Key: "advanced", Message: "unknown key"},
+	}
+	if !reflect.DeepEqual([]configError(errs), want) {
+		t.Errorf("loadConfig() errors = %#v, want %#v", errs, want)
+	}
+
+	wantMsg := tmpfile + `:3:1: n-gpu-layer: unknown key, did you mean "n-gpu-layers"?`
+	if first := strings.Split(err.Error(), "\n")[0]; first != wantMsg {
+		t.Errorf("first error line = %q, want %q", first, wantMsg)
+	}
+
+	// With strict mode off the unknown keys are ignored.
+	config, err := loadConfigWithOptions(tmpfile, loadOptions{Strict: false})
+	if err != nil {
+		t.Fatalf("loadConfigWithOptions() error = %v", err)
+	}
+	if config.ModelPath != "/path/to/model.gguf" || config.GpuLayers != 0 {
+		t.Errorf("loadConfigWithOptions() returned incorrect config: %+v", config)
+	}
+}
+
+// TestSuggestKey tests the "did you mean" suggestions for unknown keys
+func TestSuggestKey(t *testing.T) {
+	fields := yamlFields(reflect.TypeOf(LlamaConfig{}))
+	tests := []struct {
+		unknown string
+		want    string
+	}{
+		{"n-gpu-layer", "n-gpu-layers"},
+		{"gpu-layers", "n-gpu-layers"},
+		{"ctx_size", "ctx-size"},
+		{"CTX-SIZE", "ctx-size"},
+		{"flash-atn", "flash-attn"},
+		{"completely-unrelated-option", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.unknown, func(t *testing.T) {
+			if got := suggestKey(tt.unknown, fields); got != tt.want {
+				t.Errorf("suggestKey(%q) = %q, want %q", tt.unknown, got, tt.want)
+			}
+		})
+	}
+}
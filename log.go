@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// logLevels maps the names accepted by --log-level to slog levels.
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// logFormats lists the formats accepted by --log-format.
+var logFormats = []string{"text", "json"}
+
+// newLogger creates the logger for llauncher's own messages, which writes
+// records at or above level to w, as logfmt-style text or, with the json
+// format, as JSON lines. Every record has an event field naming what
+// happened, e.g. "exit", so that log pipelines can pick out launcher events
+// from llama-server's output.
+func newLogger(w io.Writer, level slog.Level, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// parseLogLevel parses a --log-level value.
+func parseLogLevel(name string) (slog.Level, error) {
+	level, ok := logLevels[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("invalid --log-level %q, must be one of: %s", name, strings.Join(slices.Sorted(maps.Keys(logLevels)), ", "))
+	}
+	return level, nil
+}
+
+// logger returns the logger for messages about a child, which names the
+// child's model in multi-model mode.
+func (s *supervisor) logger(c *child) *slog.Logger {
+	if c != nil && c.name != "" {
+		return slog.Default().With("model", c.name)
+	}
+	return slog.Default()
+}
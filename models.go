@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// modelsKey is the top-level key listing the llama-server instances to run in
+// multi-model mode. Each entry is configured like a single-model
+// configuration, and inherits every option set at the top level.
+const modelsKey = "models"
+
+// Exit policies decide when a multi-model llauncher exits, and with what
+// status.
+const (
+	// exitPolicyAnyFails stops every server as soon as one of them fails,
+	// and exits with that server's status.
+	exitPolicyAnyFails = "any-fails"
+	// exitPolicyAllFail keeps the other servers running when one fails,
+	// and only exits with a failure status if every server failed.
+	exitPolicyAllFail = "all-fail"
+)
+
+// exitPolicies lists the accepted values of exit-policy.
+var exitPolicies = []string{exitPolicyAnyFails, exitPolicyAllFail}
+
+// topLevelKeys lists the launcher settings that apply to llauncher as a
+// whole. They are not inherited by the entries of a models list, and cannot
+// be set in one.
+var topLevelKeys = []string{modelsKey, "exit-policy", "proxy", "status", "output"}
+
+// instanceKeys lists the options that must differ between the entries of a
+// models list, so that an override cannot set them for every model at once.
+// Models only share a port in swap mode, where one runs at a time.
+var instanceKeys = []string{"alias", "port"}
+
+// checkSharedOverride returns an error if a key cannot be overridden for every
+// model of a multi-model configuration.
+func checkSharedOverride(config *LlamaConfig, key string) error {
+	if len(config.Models) == 0 || !slices.Contains(instanceKeys, key) {
+		return nil
+	}
+	if key == "port" && config.Proxy.Swap {
+		return nil
+	}
+	return fmt.Errorf("cannot be overridden for every model, as each model needs its own %s; set it in the models list instead", key)
+}
+
+// defaultPort is the port llama-server listens on when none is configured.
+const defaultPort = 8080
+
+// server is one llama-server instance to run, with its full argument list.
+type server struct {
+	// name identifies the server in its log prefix. It is empty in
+	// single-model mode, where the output is not prefixed.
+	name   string
+	config *LlamaConfig
+	args   []string
+}
+
+// buildServers builds the llama-server argument lists for a configuration:
+// one for a single-model configuration, or one for each entry of its models
+// list. The passthrough arguments are appended to every list.
+func buildServers(config *LlamaConfig, passthrough []string) ([]server, error) {
+	if len(config.Models) == 0 {
+		args, err := buildArgs(config)
+		if err != nil {
+			return nil, err
+		}
+		return []server{{config: config, args: append(args, passthrough...)}}, nil
+	}
+
+	servers := make([]server, 0, len(config.Models))
+	for i := range config.Models {
+		model := &config.Models[i]
+		args, err := buildArgs(model)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", modelName(model, i), err)
+		}
+		servers = append(servers, server{
+			name:   modelName(model, i),
+			config: model,
+			args:   append(args, passthrough...),
+		})
+	}
+	return servers, nil
+}
+
+// modelName returns the name of the i'th entry of a models list: its alias,
+// or else its position.
+func modelName(model *LlamaConfig, i int) string {
+	if model.Alias != "" {
+		return model.Alias
+	}
+	return fmt.Sprintf("model-%d", i+1)
+}
+
+// effectivePort returns the port a server listens on.
+func effectivePort(config *LlamaConfig) int {
+	if config.Port != 0 || config.isSet("port") {
+		return config.Port
+	}
+	return defaultPort
+}
+
+// inheritShared merges the options set at the top level of a merged
+// configuration into each entry of its models list, so that options shared
+// by every model only need to be written once. An entry's own options win.
+func (l *configLoader) inheritShared(merged *yaml.Node) {
+	i := mappingIndex(merged, modelsKey)
+	if i < 0 || merged.Content[i+1].Kind != yaml.SequenceNode {
+		return
+	}
+
+	shared := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for j := 0; j+1 < len(merged.Content); j += 2 {
+		if !slices.Contains(topLevelKeys, merged.Content[j].Value) {
+			shared.Content = append(shared.Content, merged.Content[j], merged.Content[j+1])
+		}
+	}
+	if len(shared.Content) == 0 {
+		return
+	}
+
+	models := merged.Content[i+1]
+	for j, entry := range models.Content {
+		if entry.Kind != yaml.MappingNode {
+			continue
+		}
+		inherited := l.clone(shared)
+		mergeNodes(inherited, entry)
+		models.Content[j] = inherited
+	}
+}
+
+// clone returns a deep copy of a node tree, remembering which file each copied
+// node came from.
+func (l *configLoader) clone(node *yaml.Node) *yaml.Node {
+	copied := *node
+	copied.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		copied.Content[i] = l.clone(child)
+	}
+	if file, ok := l.files[node]; ok {
+		l.files[&copied] = file
+	}
+	return &copied
+}
+
+// validateModels checks a multi-model configuration: each model on its own,
+// and that the models do not clash with each other.
+func validateModels(config *LlamaConfig, checkFiles bool) configErrors {
+	var errs configErrors
+	aliases := make(map[string]string)
+	ports := make(map[int]string)
+	errs = append(errs, validateProxy(config)...)
+	proxyPort, _ := listenPort(config.Proxy.Listen)
+	for i := range config.Models {
+		model := &config.Models[i]
+		prefix := fmt.Sprintf("%s[%d].", modelsKey, i)
+
+		for _, key := range topLevelKeys {
+			if model.isSet(key) {
+				errs = append(errs, configError{Key: prefix + key, Message: "can only be set at the top level, not for a single model"})
+			}
+		}
+		for _, e := range validateServer(model, checkFiles) {
+			e.Key = prefix + e.Key
+			errs = append(errs, e)
+		}
+
+		if model.Alias != "" {
+			if other, ok := aliases[model.Alias]; ok {
+				errs = append(errs, configError{Key: prefix + "alias", Message: fmt.Sprintf("alias %q is already used by %s", model.Alias, other)})
+			} else {
+				aliases[model.Alias] = prefix[:len(prefix)-1]
+			}
+		}
+		if config.Proxy.Listen != "" && model.Alias == "" {
+			errs = append(errs, configError{Key: prefix + "alias", Message: "is required to route requests through the proxy"})
+		}
+		// In swap mode only one server runs at a time, so they may share
+		// a port, but not with the proxy.
+		port := effectivePort(model)
+		if port == proxyPort {
+			errs = append(errs, configError{Key: prefix + "port", Message: fmt.Sprintf("port %d is already used by proxy.listen", port)})
+		} else if other, ok := ports[port]; ok && !config.Proxy.Swap {
+			errs = append(errs, configError{Key: prefix + "port", Message: fmt.Sprintf("port %d is already used by %s", port, other)})
+		} else if !ok {
+			ports[port] = prefix[:len(prefix)-1]
+		}
+	}
+	return errs
+}
+
+// validateProxy checks the settings of the routing proxy.
+func validateProxy(config *LlamaConfig) configErrors {
+	var errs configErrors
+	proxy := config.Proxy
+	if proxy.Listen != "" {
+		if _, err := listenPort(proxy.Listen); err != nil {
+			errs = append(errs, configError{Key: "proxy.listen", Message: fmt.Sprintf("invalid address %q, must be host:port or :port", proxy.Listen)})
+		}
+	}
+	if proxy.Swap && proxy.Listen == "" {
+		errs = append(errs, configError{Key: "proxy.swap", Message: "requires proxy.listen"})
+	}
+	if proxy.IdleTTL != "" {
+		if e := validateDuration("proxy.idle-ttl", proxy.IdleTTL); e != nil {
+			errs = append(errs, e...)
+		} else if !proxy.Swap {
+			errs = append(errs, configError{Key: "proxy.idle-ttl", Message: "can only be used with proxy.swap"})
+		}
+	}
+	return errs
+}
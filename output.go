@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Output formats of llama-server's output.
+const (
+	// outputText writes each line as it is, with any prefixes.
+	outputText = "text"
+	// outputJSON re-emits each line as a JSON log record.
+	outputJSON = "json"
+)
+
+// outputFormats lists the accepted values of output.format.
+var outputFormats = []string{outputText, outputJSON}
+
+// OutputConfig configures how llama-server's output is written. Every line
+// of it is also checked for the events llauncher tracks, whatever the format.
+type OutputConfig struct {
+	// Prefix prefixes each line with the model's alias, which is always
+	// done with several models.
+	Prefix bool `yaml:"prefix"`
+	// Timestamps prefixes each line with the time it was written.
+	Timestamps bool   `yaml:"timestamps"`
+	Format     string `yaml:"format"`
+}
+
+// validateOutput checks the output settings of a configuration.
+func validateOutput(config OutputConfig) configErrors {
+	if config.Format != "" && !slices.Contains(outputFormats, config.Format) {
+		return configErrors{{
+			Key:     "output.format",
+			Message: fmt.Sprintf("invalid value %q, must be one of: %s", config.Format, strings.Join(outputFormats, ", ")),
+		}}
+	}
+	return nil
+}
+
+// outputEvent is an event recognised in llama-server's output.
+type outputEvent int
+
+const (
+	outputNone outputEvent = iota
+	// outputLoading: llama-server started loading its model.
+	outputLoading
+	// outputLoaded: llama-server loaded its model.
+	outputLoaded
+	// outputListening: llama-server serves requests.
+	outputListening
+	// outputSlotError: a request failed in one of llama-server's slots.
+	outputSlotError
+	// outputOutOfMemory: llama-server could not allocate memory, which
+	// usually means the model or context does not fit.
+	outputOutOfMemory
+)
+
+// outOfMemoryMarkers are the messages, in lower case, that llama-server and
+// its backends log when they run out of memory.
+var outOfMemoryMarkers = []string{
+	"out of memory",
+	"failed to allocate",
+	"bad_alloc",
+	"erroroutofdevicememory",
+}
+
+// classifyLine recognises the llama-server log lines llauncher tracks, e.g.
+// "main: model loaded" or "main: server is listening on http://...".
+func classifyLine(line string) outputEvent {
+	lower := strings.ToLower(line)
+	for _, marker := range outOfMemoryMarkers {
+		if strings.Contains(lower, marker) {
+			return outputOutOfMemory
+		}
+	}
+	switch {
+	case strings.Contains(line, "server is listening on"):
+		return outputListening
+	case strings.Contains(line, "model loaded"):
+		return outputLoaded
+	case strings.Contains(line, "loading model"):
+		return outputLoading
+	case strings.Contains(line, "send_error:"),
+		strings.Contains(lower, "slot") && strings.Contains(lower, "error"):
+		return outputSlotError
+	}
+	return outputNone
+}
+
+// outputWaitDelay bounds the time llama-server's output is still read for
+// after it exits.
+const outputWaitDelay = time.Second
+
+// outputRecord is a line of llama-server's output re-emitted as JSON, with
+// the fields of llauncher's own JSON log records.
+type outputRecord struct {
+	Time   string `json:"time"`
+	Level  string `json:"level"`
+	Msg    string `json:"msg"`
+	Event  string `json:"event"`
+	Model  string `json:"model,omitempty"`
+	Stream string `json:"stream"`
+}
+
+// outputWriter writes each line written to it to an underlying writer, with
+// the configured prefixes or as a JSON record, and passes any event
+// recognised in it to onEvent. Writers that share a mutex never interleave
+// their lines.
+type outputWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	config OutputConfig
+	// prefix is written before each text line, and model names the server
+	// in JSON records. stream is "stdout" or "stderr".
+	prefix  string
+	model   string
+	stream  string
+	onEvent func(event outputEvent, line string)
+	buf     []byte
+}
+
+// newOutputWriter returns an outputWriter for w, guarded by mu, for the output
+// of the server named model. The prefix is empty unless config asks for one or
+// the server is one of several, with a name.
+func newOutputWriter(w io.Writer, config OutputConfig, model, stream string, mu *sync.Mutex) *outputWriter {
+	o := &outputWriter{mu: mu, w: w, config: config, model: model, stream: stream}
+	if model != "" {
+		o.prefix = "[" + model + "] "
+	}
+	return o
+}
+
+// Write writes every complete line in b, keeping any incomplete last line
+// until the rest of it is written or the writer is flushed.
+func (o *outputWriter) Write(b []byte) (int, error) {
+	o.buf = append(o.buf, b...)
+	for {
+		i := bytes.IndexByte(o.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := o.writeLine(o.buf[:i+1]); err != nil {
+			return len(b), err
+		}
+		o.buf = o.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush writes any incomplete last line, followed by a newline.
+func (o *outputWriter) Flush() error {
+	if len(o.buf) == 0 {
+		return nil
+	}
+	line := append(o.buf, '\n')
+	o.buf = nil
+	return o.writeLine(line)
+}
+
+func (o *outputWriter) writeLine(line []byte) error {
+	text := strings.TrimRight(string(line), "\r\n")
+	event := classifyLine(text)
+	if o.onEvent != nil && event != outputNone {
+		o.onEvent(event, text)
+	}
+
+	now := time.Now()
+	var out []byte
+	if o.config.Format == outputJSON {
+		level := slog.LevelInfo
+		if event == outputSlotError || event == outputOutOfMemory {
+			level = slog.LevelError
+		}
+		record, err := json.Marshal(outputRecord{
+			Time:   now.Format(time.RFC3339Nano),
+			Level:  level.String(),
+			Msg:    text,
+			Event:  "output",
+			Model:  o.model,
+			Stream: o.stream,
+		})
+		if err != nil {
+			return err
+		}
+		out = append(record, '\n')
+	} else {
+		if o.config.Timestamps {
+			out = now.AppendFormat(out, "2006-01-02T15:04:05.000Z07:00 ")
+		}
+		out = append(append(out, o.prefix...), line...)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, err := o.w.Write(out)
+	return err
+}
+
+// newChild creates a child for a server, whose output goes through the
+// output pipeline to llauncher's stdout and stderr.
+func (s *supervisor) newChild(srv server) *child {
+	c := &child{name: srv.name}
+	c.configure(srv)
+	model := srv.name
+	if model == "" && s.output.Prefix {
+		model = "llama-server"
+		if srv.config != nil && srv.config.Alias != "" {
+			model = srv.config.Alias
+		}
+	}
+	stdout := newOutputWriter(os.Stdout, s.output, model, "stdout", &s.outputMu)
+	stderr := newOutputWriter(os.Stderr, s.output, model, "stderr", &s.outputMu)
+	stdout.onEvent = func(event outputEvent, line string) { s.observe(c, event, line) }
+	stderr.onEvent = stdout.onEvent
+	c.stdout, c.stderr = stdout, stderr
+	return c
+}
+
+// observe updates a child's state and counters from an event in its output.
+// Without health checks, the loading and loaded messages tell when the
+// server is ready.
+func (s *supervisor) observe(c *child, event outputEvent, line string) {
+	c.mu.Lock()
+	previous := c.outputState
+	switch event {
+	case outputLoading:
+		c.outputState = healthLoading
+	case outputLoaded, outputListening:
+		c.outputState = healthReady
+		if c.loadTime == 0 {
+			c.loadTime = time.Since(c.startedAt)
+		}
+	case outputSlotError:
+		c.slotErrors++
+	case outputOutOfMemory:
+		c.outOfMemory++
+	}
+	state, checked := c.outputState, c.health != nil
+	c.mu.Unlock()
+
+	if event == outputOutOfMemory {
+		s.logger(c).Error("llama-server ran out of memory", "event", "oom", "pid", c.pid(), "line", line)
+	}
+	if !checked && state != previous {
+		s.logState(c, state, nil)
+	}
+}
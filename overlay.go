@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// extendsKey is the top-level key naming the files a configuration file is
+// layered on top of. It is a directive for the loader, not a configuration
+// option, and is removed before the file is checked or decoded.
+const extendsKey = "extends"
+
+// appendTag marks a list in an overlay whose items are appended to the list
+// in the files before it, rather than replacing it.
+const appendTag = "!append"
+
+// configSource records where a final configuration value came from.
+type configSource struct {
+	Key    string
+	Value  string
+	Origin string
+	// Secret is true when the value should not be displayed.
+	Secret bool
+}
+
+// configLoader loads a stack of configuration files into a single merged
+// YAML mapping.
+type configLoader struct {
+	opts       loadOptions
+	merged     *yaml.Node
+	files      map[*yaml.Node]string
+	expansions []envExpansion
+	// loaded holds the absolute paths of the files merged so far.
+	loaded map[string]bool
+}
+
+// loadConfigFiles loads one or more configuration files and deep-merges them
+// in order, so that later files override earlier ones. Each file may also
+// name files it is based on with an `extends:` key (a file name or a list of
+// them, relative to the file), which are merged before the file itself.
+// The profile named in opts, if any, is merged last:
+//
+//   - mappings are merged key by key
+//   - any other value, including a list, replaces the earlier value
+//   - a list tagged !append is appended to the earlier list instead
+//   - a null value unsets the key
+func loadConfigFiles(paths []string, opts loadOptions) (*LlamaConfig, error) {
+	loader := &configLoader{
+		opts:   opts,
+		merged: &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"},
+		files:  make(map[*yaml.Node]string),
+		loaded: make(map[string]bool),
+	}
+	for _, path := range paths {
+		if err := loader.load(path, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	profiles, err := applyProfile(loader.merged, opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+	loader.inheritShared(loader.merged)
+
+	var config LlamaConfig
+	if err := loader.merged.Decode(&config); err != nil {
+		return nil, fmt.Errorf("could not unmarshal yaml: %w", err)
+	}
+	config.profiles = profiles
+	for i := range config.Models {
+		config.Models[i].inModels = true
+	}
+	config.expansions = loader.expansions
+	config.sources = loader.sources()
+	return &config, nil
+}
+
+// load reads one configuration file, loads the files it extends, and merges
+// it into the result. chain holds the absolute paths of the files that led
+// to this one, to detect cycles. A file that is extended again, e.g. a base
+// shared by two files one file extends, is only merged the first time, so
+// that it does not undo the overrides merged after it.
+func (l *configLoader) load(path string, chain []string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("could not resolve path %s: %w", path, err)
+	}
+	if slices.Contains(chain, abs) {
+		return fmt.Errorf("configuration files extend each other in a cycle: %s", strings.Join(append(chain, abs), " -> "))
+	}
+	if len(chain) > 0 && l.loaded[abs] {
+		return nil
+	}
+
+	yamlFile, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read yaml file: %w", err)
+	}
+
+	var root yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(yamlFile))
+	if err := decoder.Decode(&root); err != nil {
+		if errors.Is(err, io.EOF) {
+			// An empty file is an empty configuration.
+			return nil
+		}
+		return fmt.Errorf("could not unmarshal yaml: %w", err)
+	}
+
+	// The document node wraps the top-level mapping.
+	doc := &root
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		doc = doc.Content[0]
+	}
+	if doc.Kind != yaml.MappingNode {
+		return configErrors{{File: path, Line: doc.Line, Column: doc.Column, Message: "configuration must be a mapping of option names to values"}}
+	}
+
+	bases, err := takeExtends(doc, path)
+	if err != nil {
+		return err
+	}
+	for _, base := range bases {
+		if err := l.load(base, append(chain, abs)); err != nil {
+			return err
+		}
+	}
+
+	// Check the keys on the node tree rather than with the decoder's
+	// KnownFields, which stops at LlamaConfig's custom UnmarshalYAML and
+	// would only report the first unknown key.
+	errs := checkProfiles(doc, path)
+	if l.opts.Strict && len(errs) == 0 {
+		errs = checkConfigKeys(doc, path)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// Expand environment variables in values before decoding, so that the
+	// expanded values are type-checked like any other.
+	expansions, err := expandEnvNode(doc, path, os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	l.expansions = append(l.expansions, expansions...)
+
+	l.recordFile(doc, path)
+	mergeNodes(l.merged, doc)
+	l.loaded[abs] = true
+	return nil
+}
+
+// takeExtends removes the extends key from a top-level mapping and returns
+// the files it names, resolved relative to the directory of file.
+func takeExtends(doc *yaml.Node, file string) ([]string, error) {
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value != extendsKey {
+			continue
+		}
+		value := doc.Content[i+1]
+		doc.Content = slices.Delete(doc.Content, i, i+2)
+
+		var names []*yaml.Node
+		switch value.Kind {
+		case yaml.ScalarNode:
+			names = []*yaml.Node{value}
+		case yaml.SequenceNode:
+			names = value.Content
+		}
+
+		var bases []string
+		var errs configErrors
+		for _, n := range names {
+			if n.Kind != yaml.ScalarNode || n.Value == "" {
+				errs = append(errs, configError{File: file, Line: n.Line, Column: n.Column, Key: extendsKey, Message: "expected a file name"})
+				continue
+			}
+			name, _, err := expandEnv(n.Value, os.LookupEnv)
+			if err != nil {
+				errs = append(errs, configError{File: file, Line: n.Line, Column: n.Column, Key: extendsKey, Message: err.Error()})
+				continue
+			}
+			if !filepath.IsAbs(name) {
+				name = filepath.Join(filepath.Dir(file), name)
+			}
+			bases = append(bases, name)
+		}
+		if names == nil {
+			errs = append(errs, configError{File: file, Line: value.Line, Column: value.Column, Key: extendsKey, Message: "expected a file name or a list of file names"})
+		}
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		return bases, nil
+	}
+	return nil, nil
+}
+
+// mergeNodes merges the mapping src into the mapping dst. Nested mappings are
+// merged recursively, lists tagged !append are appended to, and any other
+// value replaces the one in dst.
+func mergeNodes(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		appendItems := value.Kind == yaml.SequenceNode && value.Tag == appendTag
+		if appendItems {
+			value.Tag = "!!seq"
+		}
+
+		j := mappingIndex(dst, key.Value)
+		if j < 0 {
+			dst.Content = append(dst.Content, key, value)
+			continue
+		}
+		existing := dst.Content[j+1]
+		switch {
+		case value.Kind == yaml.MappingNode && existing.Kind == yaml.MappingNode:
+			mergeNodes(existing, value)
+		case appendItems && existing.Kind == yaml.SequenceNode:
+			existing.Content = append(existing.Content, value.Content...)
+		default:
+			dst.Content[j+1] = value
+		}
+	}
+}
+
+// mappingIndex returns the index of the given key in a mapping node's
+// content, or -1 if it is not present.
+func mappingIndex(node *yaml.Node, key string) int {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// recordFile remembers that every node in a tree came from the given file.
+func (l *configLoader) recordFile(node *yaml.Node, file string) {
+	l.files[node] = file
+	for _, child := range node.Content {
+		l.recordFile(child, file)
+	}
+}
+
+// sources lists every value in the merged configuration, with the file and
+// line it came from.
+func (l *configLoader) sources() []configSource {
+	secret := make(map[string]bool)
+	for _, e := range l.expansions {
+		if e.Secret {
+			secret[e.Key] = true
+		}
+	}
+
+	var sources []configSource
+	var walk func(n *yaml.Node, key string)
+	walk = func(n *yaml.Node, key string) {
+		switch n.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				childKey := n.Content[i].Value
+				if key != "" {
+					childKey = key + "." + childKey
+				}
+				walk(n.Content[i+1], childKey)
+			}
+		case yaml.SequenceNode:
+			for i, item := range n.Content {
+				walk(item, fmt.Sprintf("%s[%d]", key, i))
+			}
+		case yaml.ScalarNode:
+			if n.ShortTag() == "!!null" {
+				return
+			}
+			sources = append(sources, configSource{
+				Key:    key,
+				Value:  n.Value,
+				Origin: fmt.Sprintf("%s:%d", l.files[n], n.Line),
+				Secret: isSecretKey(key) || secret[key],
+			})
+		}
+	}
+	walk(l.merged, "")
+	return sources
+}
+
+// setSource records that a configuration key was overridden from outside the
+// configuration files, replacing the sources of its earlier value.
+func (c *LlamaConfig) setSource(key, value, origin string) {
+	c.clearSources(key)
+	c.sources = append(c.sources, configSource{
+		Key:    key,
+		Value:  value,
+		Origin: origin,
+		Secret: isSecretKey(key),
+	})
+}
+
+// clearSources forgets where the value of a configuration key came from,
+// including the values nested under it.
+func (c *LlamaConfig) clearSources(key string) {
+	c.sources = slices.DeleteFunc(c.sources, func(s configSource) bool {
+		return s.Key == key || strings.HasPrefix(s.Key, key+".") || strings.HasPrefix(s.Key, key+"[")
+	})
+}
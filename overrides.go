@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// applySetOverrides applies --set overrides to a loaded configuration, using
+// the YAML key names. Each override is one of:
+//
+//	key=value   sets the key, replacing any earlier value; a list is given
+//	            as comma-separated values
+//	key=        unsets the key, so that it is not passed to llama-server
+//	key+=value  appends a value to a list
+//
+// All invalid overrides are reported together as a configErrors value.
+func applySetOverrides(config *LlamaConfig, sets []string) error {
+	fields := yamlFields(reflect.TypeOf(*config))
+	val := reflect.ValueOf(config).Elem()
+
+	var errs configErrors
+	for _, set := range sets {
+		key, value, ok := strings.Cut(set, "=")
+		if !ok || key == "" {
+			errs = append(errs, configError{Key: set, Message: "expected key=value or key+=value"})
+			continue
+		}
+		appendValue := strings.HasSuffix(key, "+")
+		key = strings.TrimSuffix(key, "+")
+
+		if config.inModels && slices.Contains(topLevelKeys, key) {
+			// Launcher settings only apply at the top level.
+			continue
+		}
+
+		fieldType, ok := fields[key]
+		if !ok {
+			message := "unknown key"
+			if suggestion := suggestKey(key, fields); suggestion != "" {
+				message += fmt.Sprintf(", did you mean %q?", suggestion)
+			}
+			errs = append(errs, configError{Key: key, Message: message})
+			continue
+		}
+		field := val.FieldByIndex(fieldType.Index)
+		if !isOverridable(field) {
+			errs = append(errs, configError{Key: key, Message: "cannot be set with --set"})
+			continue
+		}
+		if err := checkSharedOverride(config, key); err != nil {
+			errs = append(errs, configError{Key: key, Message: err.Error()})
+			continue
+		}
+
+		switch {
+		case appendValue:
+			if field.Kind() != reflect.Slice {
+				errs = append(errs, configError{Key: key, Message: "+= can only be used with lists"})
+				continue
+			}
+			if err := setFieldValue(field, value); err != nil {
+				errs = append(errs, configError{Key: key, Message: err.Error()})
+				continue
+			}
+			config.markSet(key)
+			config.sources = append(config.sources, configSource{
+				Key:    fmt.Sprintf("%s[%d]", key, field.Len()-1),
+				Value:  value,
+				Origin: "--set",
+				Secret: isSecretKey(key),
+			})
+		case value == "":
+			field.Set(reflect.Zero(field.Type()))
+			delete(config.set, key)
+			config.clearSources(key)
+		default:
+			if err := setOverride(field, value); err != nil {
+				errs = append(errs, configError{Key: key, Message: err.Error()})
+				continue
+			}
+			config.markSet(key)
+			config.setSource(key, value, "--set")
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// isOverridable reports whether a configuration field can be set from a
+// string, by an environment variable or --set.
+func isOverridable(field reflect.Value) bool {
+	switch field.Kind() {
+	case reflect.Bool, reflect.String, reflect.Int, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return field.Type().Elem().Kind() == reflect.String
+	}
+	return false
+}
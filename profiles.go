@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profilesKey is the top-level key holding named profiles, each of which
+// overrides keys of the shared configuration around it:
+//
+//	model: /models/a.gguf
+//	ctx-size: 8192
+//	profiles:
+//	  long-context:
+//	    ctx-size: 131072
+//	  embedding:
+//	    embedding: true
+//	    pooling: mean
+//
+// Like extends, it is a directive for the loader rather than an option.
+const profilesKey = "profiles"
+
+// profileEnvVar names the environment variable that selects a profile when
+// --profile is not given.
+const profileEnvVar = "LLAUNCHER_PROFILE"
+
+// resolveProfile returns the profile to use: the one given on the command
+// line, or else the one named by LLAUNCHER_PROFILE.
+func resolveProfile(name string) string {
+	if name != "" {
+		return name
+	}
+	return os.Getenv(profileEnvVar)
+}
+
+// checkConfigKeys reports every unknown key in a configuration file,
+// including the keys of each profile.
+func checkConfigKeys(doc *yaml.Node, file string) configErrors {
+	typ := reflect.TypeOf(LlamaConfig{})
+	errs := checkKnownKeys(withoutKey(doc, profilesKey), typ, file, "")
+	if i := mappingIndex(doc, profilesKey); i >= 0 && doc.Content[i+1].Kind == yaml.MappingNode {
+		profiles := doc.Content[i+1]
+		for j := 0; j+1 < len(profiles.Content); j += 2 {
+			prefix := profilesKey + "." + profiles.Content[j].Value + "."
+			errs = append(errs, checkKnownKeys(profiles.Content[j+1], typ, file, prefix)...)
+		}
+	}
+	return errs
+}
+
+// checkProfiles checks that the profiles section of a configuration file, if
+// any, is a mapping of profile names to mappings of options.
+func checkProfiles(doc *yaml.Node, file string) configErrors {
+	i := mappingIndex(doc, profilesKey)
+	if i < 0 {
+		return nil
+	}
+	profiles := doc.Content[i+1]
+	if profiles.Kind != yaml.MappingNode {
+		return configErrors{{File: file, Line: profiles.Line, Column: profiles.Column, Key: profilesKey, Message: "expected a mapping of profile names to options"}}
+	}
+
+	var errs configErrors
+	for j := 0; j+1 < len(profiles.Content); j += 2 {
+		name, profile := profiles.Content[j], profiles.Content[j+1]
+		if profile.Kind != yaml.MappingNode && profile.ShortTag() != "!!null" {
+			errs = append(errs, configError{File: file, Line: profile.Line, Column: profile.Column, Key: profilesKey + "." + name.Value, Message: "expected a mapping of options"})
+		}
+	}
+	return errs
+}
+
+// withoutKey returns a shallow copy of a mapping node without the given key.
+func withoutKey(node *yaml.Node, key string) *yaml.Node {
+	i := mappingIndex(node, key)
+	if i < 0 {
+		return node
+	}
+	copied := *node
+	copied.Content = slices.Delete(slices.Clone(node.Content), i, i+2)
+	return &copied
+}
+
+// applyProfile removes the profiles section from a merged configuration and,
+// if a profile is named, merges that profile over the rest of it. It returns
+// the names of all the profiles, in the order they are defined.
+func applyProfile(merged *yaml.Node, name string) ([]string, error) {
+	var names []string
+	var selected *yaml.Node
+	if i := mappingIndex(merged, profilesKey); i >= 0 {
+		profiles := merged.Content[i+1]
+		merged.Content = slices.Delete(merged.Content, i, i+2)
+		for j := 0; j+1 < len(profiles.Content); j += 2 {
+			names = append(names, profiles.Content[j].Value)
+			if profiles.Content[j].Value == name {
+				selected = profiles.Content[j+1]
+			}
+		}
+	}
+
+	if name == "" {
+		return names, nil
+	}
+	if !slices.Contains(names, name) {
+		if len(names) == 0 {
+			return nil, fmt.Errorf("unknown profile %q; no profiles are defined", name)
+		}
+		return nil, fmt.Errorf("unknown profile %q; available profiles: %s", name, strings.Join(names, ", "))
+	}
+	if selected.Kind == yaml.MappingNode {
+		mergeNodes(merged, selected)
+	}
+	return names, nil
+}
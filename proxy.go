@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ProxyConfig configures the routing proxy, which gives several llama-server
+// instances a single OpenAI-compatible endpoint. Requests are routed to the
+// instance whose alias matches the request's model.
+type ProxyConfig struct {
+	// Listen is the address the proxy listens on, e.g. ":8080".
+	Listen string `yaml:"listen"`
+	// Swap runs only one llama-server at a time, started by the first
+	// request for its model, for models that do not fit in memory together.
+	Swap bool `yaml:"swap"`
+	// IdleTTL, in swap mode, stops a llama-server that has served no
+	// requests for this long, e.g. "10m". It is never stopped if empty.
+	IdleTTL string `yaml:"idle-ttl"`
+}
+
+// routedPaths lists the API endpoints that are routed by the model named in
+// the request body.
+var routedPaths = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+}
+
+// maxRequestBody limits the size of a request body the proxy reads to find
+// the model, which bounds memory use for each request.
+const maxRequestBody = 64 << 20
+
+// upstream is a llama-server instance behind the proxy.
+type upstream struct {
+	alias  string
+	url    *url.URL
+	proxy  *httputil.ReverseProxy
+	health *healthCheck
+}
+
+// routingProxy routes OpenAI-compatible API requests to the llama-server
+// instance whose alias matches the request's model, and lists the models of
+// every instance.
+type routingProxy struct {
+	upstreams []*upstream
+	byAlias   map[string]*upstream
+	client    *http.Client
+	// swap, in swap mode, starts the server for a model on demand.
+	swap *swapper
+}
+
+// newRoutingProxy creates a proxy in front of the given servers.
+func newRoutingProxy(servers []server) *routingProxy {
+	transport := upstreamTransport()
+	p := &routingProxy{
+		byAlias: make(map[string]*upstream),
+		client:  &http.Client{Transport: transport, Timeout: 10 * time.Second},
+	}
+	for _, srv := range servers {
+		target := upstreamURL(srv.config)
+		u := &upstream{
+			alias:  srv.config.Alias,
+			url:    target,
+			health: newHealthCheck(srv.config),
+			proxy: &httputil.ReverseProxy{
+				Rewrite: func(r *httputil.ProxyRequest) {
+					r.SetURL(target)
+					r.SetXForwarded()
+				},
+				Transport: transport,
+				// Flush every write, so that streamed (SSE) responses
+				// reach the client token by token.
+				FlushInterval: -1,
+				ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+					writeAPIError(w, http.StatusBadGateway, "server_error", fmt.Sprintf("model %q is not available: %v", srv.config.Alias, err))
+				},
+			},
+		}
+		p.upstreams = append(p.upstreams, u)
+		p.byAlias[u.alias] = u
+	}
+	return p
+}
+
+// upstreamTransport returns a transport for requests to llama-server. Its
+// certificate is usually self-signed, and it is reached over the loopback
+// interface, so it is not verified.
+func upstreamTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	return transport
+}
+
+// upstreamURL returns the base URL of a llama-server instance, as reached
+// from llauncher, including any API prefix.
+func upstreamURL(config *LlamaConfig) *url.URL {
+	host := config.Host
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	scheme := "http"
+	if config.SslCertFile != "" {
+		scheme = "https"
+	}
+	return &url.URL{
+		Scheme: scheme,
+		Host:   net.JoinHostPort(host, strconv.Itoa(effectivePort(config))),
+		Path:   config.ApiPrefix,
+	}
+}
+
+// ServeHTTP routes a request to the llama-server instance for its model.
+func (p *routingProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/v1/models" && r.Method == http.MethodGet:
+		p.serveModels(w, r)
+	case routedPaths[r.URL.Path]:
+		p.serveRouted(w, r)
+	default:
+		writeAPIError(w, http.StatusNotFound, "invalid_request_error", fmt.Sprintf("unknown endpoint: %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// serveRouted forwards a request to the instance named by the model field of
+// its JSON body. With a single instance the model may be left out.
+func (p *routingProxy) serveRouted(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err != nil {
+		// Only a body over the limit is too large; any other error, such as
+		// the client going away, makes the request itself bad.
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeAPIError(w, status, "invalid_request_error", fmt.Sprintf("could not read request body: %v", err))
+		return
+	}
+	var request struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("request body is not valid JSON: %v", err))
+		return
+	}
+
+	u, ok := p.byAlias[request.Model]
+	if !ok && request.Model == "" && len(p.upstreams) == 1 {
+		u, ok = p.upstreams[0], true
+	}
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "invalid_request_error", fmt.Sprintf("model %q does not exist", request.Model))
+		return
+	}
+
+	if p.swap != nil {
+		release, err := p.swap.acquire(r.Context(), u)
+		if err != nil {
+			writeAPIError(w, http.StatusServiceUnavailable, "server_error", err.Error())
+			return
+		}
+		defer release()
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	u.proxy.ServeHTTP(w, r)
+}
+
+// serveModels lists the models of every instance. An instance that cannot
+// be asked, e.g. because it is still loading, is listed by its alias, as is
+// every instance in swap mode, where they are not all running.
+func (p *routingProxy) serveModels(w http.ResponseWriter, r *http.Request) {
+	lists := make([][]json.RawMessage, len(p.upstreams))
+	var wg sync.WaitGroup
+	for i, u := range p.upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lists[i] = []json.RawMessage{aliasEntry(u.alias)}
+			if p.swap != nil {
+				return
+			}
+			if models, err := p.fetchModels(r.Context(), u, r.Header.Get("Authorization")); err == nil {
+				lists[i] = models
+			}
+		}()
+	}
+	wg.Wait()
+
+	data := []json.RawMessage{}
+	for _, models := range lists {
+		data = append(data, models...)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": data})
+}
+
+// aliasEntry returns the entry of the model list for a model known only by
+// its alias.
+func aliasEntry(alias string) json.RawMessage {
+	entry, _ := json.Marshal(map[string]any{"id": alias, "object": "model", "owned_by": "llamacpp"})
+	return entry
+}
+
+// fetchModels asks one instance for its list of models.
+func (p *routingProxy) fetchModels(ctx context.Context, u *upstream, authorization string) ([]json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.url.JoinPath("/v1/models").String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var list struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	return list.Data, nil
+}
+
+// writeAPIError writes an error response in the format of the OpenAI API.
+func writeAPIError(w http.ResponseWriter, status int, errorType string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    errorType,
+			"code":    status,
+		},
+	})
+}
+
+// startProxy starts serving the routing proxy on the given address. It
+// returns a function that stops the proxy.
+func startProxy(listen string, proxy *routingProxy) (func(), error) {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, fmt.Errorf("could not start proxy: %w", err)
+	}
+	srv := &http.Server{Handler: proxy}
+	go srv.Serve(listener)
+	return func() { srv.Close() }, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// reapInterval is how often the reaper looks for zombies without being told
+// that a child exited, which catches orphans whose SIGCHLD arrived while it
+// was waiting for one of llauncher's own children to be reaped.
+const reapInterval = time.Second
+
+// prSetChildSubreaper is the prctl option that makes a process the reaper of
+// its orphaned descendants.
+const prSetChildSubreaper = 36
+
+// pAll is the idtype of waitid that waits for any child.
+const pAll = 0
+
+// siginfo is the start of the kernel's siginfo_t, as filled in by waitid for
+// a child. The padding aligns pid with the union that follows code.
+type siginfo struct {
+	signo int32
+	errno int32
+	code  int32
+	_     [unsafe.Sizeof(uintptr(0)) - 4]byte
+	pid   int32
+	_     [128]byte
+}
+
+// startReaper makes llauncher reap the orphaned processes that are reparented
+// to it, as an init process must: as PID 1 every orphan in the container is,
+// and otherwise llauncher becomes a child subreaper, adopting the orphans of
+// its own descendants. llauncher's own children are left to the supervisor,
+// which reports their exit status. It returns a function that stops reaping.
+func startReaper(s *supervisor) (func(), error) {
+	if os.Getpid() != 1 {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+			return nil, fmt.Errorf("could not become a child subreaper: %w", errno)
+		}
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGCHLD)
+	stop := make(chan struct{})
+	go func() {
+		defer signal.Stop(sigChan)
+		ticker := time.NewTicker(reapInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-sigChan:
+			case <-ticker.C:
+			}
+			s.reapOrphans()
+		}
+	}()
+	return func() { close(stop) }, nil
+}
+
+// reapOrphans reaps every exited process that is not one of the supervisor's
+// children. It looks at each zombie without reaping it first, and stops at a
+// child of the supervisor, which exec reaps once it is told.
+func (s *supervisor) reapOrphans() {
+	for {
+		var info siginfo
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pAll, 0, uintptr(unsafe.Pointer(&info)),
+			syscall.WEXITED|syscall.WNOHANG|syscall.WNOWAIT, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 || info.pid == 0 || s.isChild(int(info.pid)) {
+			return
+		}
+
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(int(info.pid), &status, syscall.WNOHANG, nil)
+		if err != nil && !errors.Is(err, syscall.EINTR) {
+			return
+		}
+		if pid > 0 {
+			slog.Debug("Reaped orphaned process", "event", "reap", "pid", pid, "exit_code", status.ExitStatus())
+		}
+	}
+}
+
+// isChild reports whether pid is the current process of one of the
+// supervisor's children.
+func (s *supervisor) isChild(pid int) bool {
+	s.mu.Lock()
+	children := append(slices.Clone(s.children), s.replacing...)
+	s.mu.Unlock()
+	for _, c := range children {
+		if c.runningPID() == pid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// startReaper is only supported on Linux, which containers run on.
+func startReaper(s *supervisor) (func(), error) {
+	return nil, errors.New("init mode is only supported on Linux")
+}
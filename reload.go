@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"syscall"
+)
+
+// reloadConfig loads the configuration again and restarts each server whose
+// command changed, stopping its current process gracefully first. If the new
+// configuration is invalid, the running servers are left as they are.
+func (s *supervisor) reloadConfig(trigger string) {
+	slog.Info("Reloading the configuration", "event", "reload", "trigger", trigger)
+	config, servers, err := s.reload()
+	if err == nil {
+		err = s.checkReload(config, servers)
+	}
+	if err != nil {
+		slog.Error("Keeping the running configuration", "event", "reload", "error", err)
+		return
+	}
+
+	s.config, s.servers = config, servers
+	s.mu.Lock()
+	s.configHash = configHash(servers)
+	s.mu.Unlock()
+
+	restarted := 0
+	for i, srv := range servers {
+		c := s.children[i]
+		if !c.reconfigure(srv) {
+			continue
+		}
+		restarted++
+		s.logger(c).Info("Restarting llama-server with its new command", "event", "reload", "pid", c.pid())
+		if err := s.terminate(c, syscall.SIGTERM); err != nil {
+			s.logger(c).Debug("Failed to stop llama-server", "event", "stop", "error", err)
+		}
+	}
+	if restarted == 0 {
+		slog.Info("No llama-server command changed, nothing to restart", "event", "reload")
+	}
+}
+
+// checkReload checks that a reloaded configuration can be applied to the
+// running servers. Only the servers themselves can change: adding or removing
+// models, or changing llauncher's own settings, needs a restart of llauncher.
+func (s *supervisor) checkReload(config *LlamaConfig, servers []server) error {
+	if len(servers) != len(s.servers) {
+		return errors.New("models cannot be added or removed without restarting llauncher")
+	}
+	for i, srv := range servers {
+		if srv.name != s.servers[i].name {
+			return fmt.Errorf("model %s cannot be renamed to %s without restarting llauncher", s.servers[i].name, srv.name)
+		}
+	}
+	if config.ExitPolicy != s.config.ExitPolicy || !reflect.DeepEqual(config.Proxy, s.config.Proxy) || !reflect.DeepEqual(config.Status, s.config.Status) || config.Output != s.config.Output {
+		return errors.New("exit-policy, proxy, status and output cannot change without restarting llauncher")
+	}
+	if config.Proxy.Listen != "" {
+		for i, srv := range servers {
+			if upstreamURL(srv.config).String() != upstreamURL(s.servers[i].config).String() {
+				return fmt.Errorf("model %s cannot move to another address while the routing proxy runs", srv.name)
+			}
+		}
+	}
+	return nil
+}
+
+// reconfigure sets the server a child runs from its next start, and reports
+// whether its running process must be stopped because its command changed.
+func (c *child) reconfigure(srv server) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopping {
+		return false
+	}
+	c.next = &srv
+	c.reloading = c.running && !slices.Equal(c.args, srv.args)
+	return c.reloading
+}
+
+// applyReload switches the child to the server it was reconfigured with, if
+// any, and reports whether its last process was stopped to do so.
+func (c *child) applyReload() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.next == nil {
+		return false
+	}
+	c.configure(*c.next)
+	reloading := c.reloading
+	c.next, c.reloading = nil, false
+	return reloading
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Restart policies decide whether llama-server is started again after it
+// exits on its own.
+const (
+	// restartNever lets llauncher exit with llama-server, so that the
+	// orchestrator decides what to do. This is the default.
+	restartNever = "never"
+	// restartOnFailure restarts llama-server when it exits with a non-zero
+	// status or is killed by a signal, e.g. after a CUDA out-of-memory error.
+	restartOnFailure = "on-failure"
+	// restartAlways restarts llama-server whenever it exits.
+	restartAlways = "always"
+)
+
+// restartPolicies lists the accepted values of restart.policy.
+var restartPolicies = []string{restartNever, restartOnFailure, restartAlways}
+
+// Defaults of the restart backoff.
+const (
+	defaultRestartBackoff    = time.Second
+	defaultRestartMaxBackoff = time.Minute
+	defaultRestartResetAfter = time.Minute
+)
+
+// RestartConfig configures restarting llama-server after it exits. Durations
+// are written like "500ms", "10s" or "2m".
+type RestartConfig struct {
+	Policy string `yaml:"policy"`
+	// MaxRetries limits the number of restarts in a row; 0 is unlimited.
+	MaxRetries int `yaml:"max-retries"`
+	// Backoff is the delay before the first restart, which doubles with
+	// each further restart in a row, up to MaxBackoff.
+	Backoff    string `yaml:"backoff"`
+	MaxBackoff string `yaml:"max-backoff"`
+	// ResetAfter is how long llama-server must run before the restarts in a
+	// row are counted from zero again. With health checks, it counts from
+	// the first passed check, so a server that never becomes ready keeps
+	// counting.
+	ResetAfter string `yaml:"reset-after"`
+}
+
+// errStopping is returned when starting a server that is being stopped.
+var errStopping = errors.New("llama-server is being stopped")
+
+// restartPolicy is a RestartConfig with its defaults applied.
+type restartPolicy struct {
+	policy     string
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	resetAfter time.Duration
+}
+
+// newRestartPolicy applies the defaults to a validated RestartConfig.
+func newRestartPolicy(config RestartConfig) restartPolicy {
+	p := restartPolicy{
+		policy:     config.Policy,
+		maxRetries: config.MaxRetries,
+		backoff:    parseDurationOr(config.Backoff, defaultRestartBackoff),
+		maxBackoff: parseDurationOr(config.MaxBackoff, defaultRestartMaxBackoff),
+		resetAfter: parseDurationOr(config.ResetAfter, defaultRestartResetAfter),
+	}
+	if p.policy == "" {
+		p.policy = restartNever
+	}
+	return p
+}
+
+// parseDurationOr parses a validated duration, or returns def if it is empty.
+func parseDurationOr(value string, def time.Duration) time.Duration {
+	if value == "" {
+		return def
+	}
+	d, _ := time.ParseDuration(value)
+	return d
+}
+
+// shouldRestart reports whether llama-server is restarted after exiting with
+// the given result from exec.
+func (p restartPolicy) shouldRestart(err error) bool {
+	switch p.policy {
+	case restartAlways:
+		return true
+	case restartOnFailure:
+		return err != nil
+	}
+	return false
+}
+
+// delay returns how long to wait before the given restart in a row, counted
+// from 1: the backoff doubled for each earlier restart, up to the maximum,
+// with up to half of it randomised so that servers that failed together do
+// not restart together.
+func (p restartPolicy) delay(restart int) time.Duration {
+	d := p.backoff
+	for i := 1; i < restart && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, p.maxBackoff)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// validateRestart checks the restart settings of a configuration.
+func validateRestart(config RestartConfig) configErrors {
+	var errs configErrors
+	if config.Policy != "" && !slices.Contains(restartPolicies, config.Policy) {
+		errs = append(errs, configError{
+			Key:     "restart.policy",
+			Message: fmt.Sprintf("invalid value %q, must be one of: %s", config.Policy, strings.Join(restartPolicies, ", ")),
+		})
+	}
+	if config.MaxRetries < 0 {
+		errs = append(errs, configError{
+			Key:     "restart.max-retries",
+			Message: fmt.Sprintf("invalid value %d, must not be negative", config.MaxRetries),
+		})
+	}
+	for _, d := range []struct{ key, value string }{
+		{"restart.backoff", config.Backoff},
+		{"restart.max-backoff", config.MaxBackoff},
+		{"restart.reset-after", config.ResetAfter},
+	} {
+		errs = append(errs, validateDuration(d.key, d.value)...)
+	}
+	return errs
+}
+
+// validateDuration checks that a setting is empty or a duration that is not
+// negative.
+func validateDuration(key, value string) configErrors {
+	if value == "" {
+		return nil
+	}
+	if d, err := time.ParseDuration(value); err != nil || d < 0 {
+		return configErrors{{Key: key, Message: fmt.Sprintf("invalid duration %q, e.g. 30s or 10m", value)}}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// signalNames maps the names of the signals llama-server may be stopped with
+// to the signals.
+var signalNames = map[string]syscall.Signal{
+	"SIGINT":  syscall.SIGINT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGKILL": syscall.SIGKILL,
+}
+
+// signalName returns the name of a signal, such as "SIGTERM".
+func signalName(sig syscall.Signal) string {
+	for name, s := range signalNames {
+		if s == sig {
+			return name
+		}
+	}
+	return sig.String()
+}
+
+// parseSignal parses a validated signal name, with or without its "SIG"
+// prefix, or returns def if it is empty.
+func parseSignal(name string, def syscall.Signal) syscall.Signal {
+	if name == "" {
+		return def
+	}
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	return signalNames[name]
+}
+
+// shutdownPolicy decides how llama-server is stopped.
+type shutdownPolicy struct {
+	// timeout is how long llama-server may take to stop before its process
+	// group is killed with SIGKILL. It may take as long as it needs if 0.
+	timeout time.Duration
+	// stopSignal is sent to llama-server instead of SIGTERM.
+	stopSignal syscall.Signal
+}
+
+// newShutdownPolicy reads the shutdown settings of a validated configuration.
+func newShutdownPolicy(config *LlamaConfig) shutdownPolicy {
+	return shutdownPolicy{
+		timeout:    parseDurationOr(config.ShutdownTimeout, 0),
+		stopSignal: parseSignal(config.StopSignal, syscall.SIGTERM),
+	}
+}
+
+// terminate asks a child to stop with a signal, sending its stop signal in
+// place of SIGTERM, and kills its process group if it has not exited after
+// its shutdown timeout. The child records which of these stopped it.
+func (s *supervisor) terminate(c *child, sig syscall.Signal) error {
+	c.mu.Lock()
+	policy := c.shutdown
+	c.mu.Unlock()
+	if sig == syscall.SIGTERM && policy.stopSignal != 0 {
+		sig = policy.stopSignal
+	}
+	// A child waiting to be restarted has no process to stop.
+	pid := c.runningPID()
+	if pid == 0 {
+		return errNotRunning
+	}
+	c.setStoppedBy(signalName(sig))
+	s.logger(c).Debug("Stopping llama-server", "event", "stop", "pid", pid, "signal", signalName(sig))
+	if err := c.signalProcess(pid, sig); err != nil {
+		return err
+	}
+
+	if timeout := policy.timeout; timeout > 0 && sig != syscall.SIGKILL {
+		time.AfterFunc(timeout, func() {
+			if !c.escalate(pid, fmt.Sprintf("SIGKILL after the %s shutdown timeout", timeout)) {
+				return
+			}
+			s.logger(c).Warn("llama-server did not stop in time, killing it", "event", "kill", "pid", pid, "signal", "SIGKILL", "timeout", timeout.String(), "stop_signal", signalName(sig))
+			c.signalProcess(pid, syscall.SIGKILL)
+		})
+	}
+	return nil
+}
+
+// reportExit reports how a child exited, naming what stopped it if it was
+// asked to stop, and as an error if it failed by itself. Each exit is only
+// reported once, whichever path handles it.
+func (s *supervisor) reportExit(c *child, err error) {
+	if !c.markExitReported() {
+		return
+	}
+	msg := strings.TrimSuffix(describeExit(err), ".")
+	attrs := []any{"event", "exit", "pid", c.pid(), "exit_code", exitCode(err)}
+	switch by := c.stopStage(); {
+	case by != "":
+		s.logger(c).Info(msg, append(attrs, "stopped_by", by)...)
+	case err != nil:
+		s.logger(c).Error(msg, attrs...)
+	default:
+		s.logger(c).Info(msg, attrs...)
+	}
+}
+
+// validateShutdown checks the shutdown settings of a configuration.
+func validateShutdown(config *LlamaConfig) configErrors {
+	errs := validateDuration("shutdown-timeout", config.ShutdownTimeout)
+	if config.StopSignal != "" && parseSignal(config.StopSignal, 0) == 0 {
+		errs = append(errs, configError{
+			Key:     "stop-signal",
+			Message: fmt.Sprintf("invalid value %q, must be one of: %s", config.StopSignal, strings.Join(slices.Sorted(maps.Keys(signalNames)), ", ")),
+		})
+	}
+	return errs
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// StatusConfig configures llauncher's own HTTP endpoint, which reports the
+// state of the servers it supervises to orchestrators, e.g. for Kubernetes
+// liveness and readiness probes.
+type StatusConfig struct {
+	// Listen is the address the endpoint listens on, e.g. ":9090".
+	Listen string `yaml:"listen"`
+}
+
+// serverStatus is the state of one llama-server, as reported by /status.
+type serverStatus struct {
+	Name  string `json:"name,omitempty"`
+	State string `json:"state"`
+	// PID is the process ID of the running server, or 0.
+	PID           int     `json:"pid"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Restarts      int     `json:"restarts"`
+	// LastExitCode is the exit code of the server's last process, if it
+	// has exited.
+	LastExitCode *int `json:"last_exit_code"`
+	Ready        bool `json:"ready"`
+	// ModelLoadSeconds is the time the running server took to load its
+	// model, once its output reported it loaded.
+	ModelLoadSeconds float64 `json:"model_load_seconds,omitempty"`
+	// SlotErrors and OutOfMemoryErrors count the errors in the server's
+	// output since llauncher started.
+	SlotErrors        int `json:"slot_errors"`
+	OutOfMemoryErrors int `json:"out_of_memory_errors"`
+}
+
+// launcherStatus is the state of llauncher, as reported by /status.
+type launcherStatus struct {
+	PID           int            `json:"pid"`
+	UptimeSeconds float64        `json:"uptime_seconds"`
+	ConfigHash    string         `json:"config_hash"`
+	Ready         bool           `json:"ready"`
+	Servers       []serverStatus `json:"servers"`
+}
+
+// States reported for a server besides its health states.
+const (
+	// stateStopped: the server is not running, e.g. while it waits to be
+	// restarted, or in swap mode until its model is requested.
+	stateStopped = "stopped"
+)
+
+// status returns the state of the child.
+func (c *child) status() serverStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := serverStatus{
+		Name:              c.name,
+		State:             stateStopped,
+		Restarts:          c.restarts,
+		SlotErrors:        c.slotErrors,
+		OutOfMemoryErrors: c.outOfMemory,
+	}
+	if c.lastExit != nil {
+		code := *c.lastExit
+		st.LastExitCode = &code
+	}
+	if !c.running {
+		return st
+	}
+	st.PID = c.cmd.Process.Pid
+	st.UptimeSeconds = time.Since(c.startedAt).Seconds()
+	st.ModelLoadSeconds = c.loadTime.Seconds()
+	switch {
+	case c.health != nil:
+		st.State = string(c.state)
+		st.Ready = c.state == healthReady
+	case c.outputState != "":
+		// Without health checks, the output tells while the model loads.
+		st.State = string(c.outputState)
+		st.Ready = c.outputState == healthReady
+	default:
+		// Nothing has shown that the model is loaded yet.
+		st.State = string(healthStarting)
+	}
+	return st
+}
+
+// status returns the state of llauncher and every server it supervises. It
+// is ready when every server is, or in swap mode, where servers are started
+// on demand, as soon as it runs.
+func (s *supervisor) status() launcherStatus {
+	s.mu.Lock()
+	hash, children := s.configHash, s.children
+	s.mu.Unlock()
+	st := launcherStatus{
+		PID:           os.Getpid(),
+		UptimeSeconds: time.Since(s.startedAt).Seconds(),
+		ConfigHash:    hash,
+		Ready:         true,
+	}
+	for _, c := range children {
+		cs := c.status()
+		st.Ready = st.Ready && (cs.Ready || s.onDemand)
+		st.Servers = append(st.Servers, cs)
+	}
+	return st
+}
+
+// configHash identifies the commands run for a configuration, so that it can
+// be told when a different configuration is in use.
+func configHash(servers []server) string {
+	h := sha256.New()
+	for _, srv := range servers {
+		json.NewEncoder(h).Encode([]any{srv.name, srv.args})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// statusHandler serves the status endpoints of a supervisor:
+//   - /livez answers 200 while llauncher runs
+//   - /readyz answers 200 when the servers are ready, and 503 otherwise
+//   - /status describes llauncher and its servers as JSON
+//   - /metrics exports the state of the servers as Prometheus metrics
+func statusHandler(s *supervisor) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /livez", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.status().Ready {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.status())
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, s.status())
+	})
+	return mux
+}
+
+// serverMetrics lists the metrics exported for each server.
+var serverMetrics = []struct {
+	name, kind, help string
+	value            func(serverStatus) float64
+}{
+	{"llauncher_server_up", "gauge", "Whether llama-server is running.", func(st serverStatus) float64 { return boolMetric(st.PID != 0) }},
+	{"llauncher_server_ready", "gauge", "Whether llama-server is ready to serve requests.", func(st serverStatus) float64 { return boolMetric(st.Ready) }},
+	{"llauncher_server_restarts_total", "counter", "Restarts of llama-server.", func(st serverStatus) float64 { return float64(st.Restarts) }},
+	{"llauncher_server_model_load_seconds", "gauge", "Time the running llama-server took to load its model.", func(st serverStatus) float64 { return st.ModelLoadSeconds }},
+	{"llauncher_server_slot_errors_total", "counter", "Slot errors logged by llama-server.", func(st serverStatus) float64 { return float64(st.SlotErrors) }},
+	{"llauncher_server_out_of_memory_errors_total", "counter", "Out of memory errors logged by llama-server.", func(st serverStatus) float64 { return float64(st.OutOfMemoryErrors) }},
+}
+
+// writeMetrics writes the state of the servers in the Prometheus text format,
+// labelling each server's metrics with its name in multi-model mode.
+func writeMetrics(w io.Writer, st launcherStatus) {
+	for _, m := range serverMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, server := range st.Servers {
+			labels := ""
+			if server.Name != "" {
+				labels = fmt.Sprintf("{model=%q}", server.Name)
+			}
+			fmt.Fprintf(w, "%s%s %s\n", m.name, labels, strconv.FormatFloat(m.value(server), 'g', -1, 64))
+		}
+	}
+}
+
+// boolMetric converts a condition to a metric value.
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// startStatusServer starts serving the status endpoints of a supervisor on the
+// given address. It returns a function that stops serving them.
+func startStatusServer(listen string, s *supervisor) (func(), error) {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, fmt.Errorf("could not start status endpoint: %w", err)
+	}
+	srv := &http.Server{Handler: statusHandler(s)}
+	go srv.Serve(listener)
+	return func() { srv.Close() }, nil
+}
+
+// listenPort returns the port of a listen address, such as ":8080".
+func listenPort(address string) (int, error) {
+	_, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0, err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", portText)
+	}
+	return port, nil
+}
+
+// validateStatus checks the status endpoint's address, which must not clash
+// with any server's or the proxy's.
+func validateStatus(config *LlamaConfig) configErrors {
+	listen := config.Status.Listen
+	if listen == "" {
+		return nil
+	}
+	port, err := listenPort(listen)
+	if err != nil {
+		return configErrors{{Key: "status.listen", Message: fmt.Sprintf("invalid address %q, must be host:port or :port", listen)}}
+	}
+
+	type use struct {
+		key  string
+		port int
+	}
+	var used []use
+	if p, err := listenPort(config.Proxy.Listen); err == nil {
+		used = append(used, use{"proxy.listen", p})
+	}
+	if len(config.Models) == 0 {
+		used = append(used, use{"port", effectivePort(config)})
+	}
+	for i := range config.Models {
+		used = append(used, use{fmt.Sprintf("%s[%d].port", modelsKey, i), effectivePort(&config.Models[i])})
+	}
+	for _, u := range used {
+		if u.port == port {
+			return configErrors{{Key: "status.listen", Message: fmt.Sprintf("port %d is already used by %s", port, u.key)}}
+		}
+	}
+	return nil
+}
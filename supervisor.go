@@ -0,0 +1,566 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// forwardedSignals lists the signals that are forwarded to llama-server.
+// SIGKILL and SIGSTOP cannot be caught, so they are omitted.
+var forwardedSignals = []os.Signal{
+	syscall.SIGINT,
+	syscall.SIGTERM,
+	syscall.SIGQUIT,
+	syscall.SIGHUP,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+}
+
+// isTermination reports whether a signal asks llauncher to stop, in which case
+// llama-server is not restarted after it exits.
+func isTermination(sig os.Signal) bool {
+	return sig == syscall.SIGINT || sig == syscall.SIGTERM || sig == syscall.SIGQUIT
+}
+
+// child is one llama-server process run by the supervisor.
+type child struct {
+	name     string
+	args     []string
+	stdout   io.Writer
+	stderr   io.Writer
+	restart  restartPolicy
+	shutdown shutdownPolicy
+	// health checks the child's health, if enabled.
+	health *healthCheck
+
+	// mu guards the fields below, which change when the child is
+	// restarted or stopped.
+	mu        sync.Mutex
+	cmd       *exec.Cmd
+	startedAt time.Time
+	// readyAt is the time the current process first passed its health
+	// check, if it has.
+	readyAt  time.Time
+	restarts int
+	state    healthState
+	running  bool
+	lastExit *int
+	// stopping is set, and stopped closed, once the child has been asked
+	// to stop, after which it is not restarted.
+	stopping bool
+	stopped  chan struct{}
+	// stoppedBy describes what the current process was last asked to stop
+	// by, if anything, and exitReported is set once its exit is reported.
+	stoppedBy    string
+	exitReported bool
+	// next is the server a reload configured the child to run from its
+	// next start, and reloading is set if the current process is being
+	// stopped to start it.
+	next      *server
+	reloading bool
+	// outputState is the state the server's output last reported, and
+	// loadTime the time it took to load the model. They are reset on each
+	// start, while the error counts add up over restarts.
+	outputState healthState
+	loadTime    time.Duration
+	slotErrors  int
+	outOfMemory int
+}
+
+// configure sets the command and policies the child runs with.
+func (c *child) configure(srv server) {
+	c.args = srv.args
+	if srv.config == nil {
+		return
+	}
+	c.restart = newRestartPolicy(srv.config.Restart)
+	c.shutdown = newShutdownPolicy(srv.config)
+	c.health = nil
+	if srv.config.Health.Enabled {
+		c.health = newHealthCheck(srv.config)
+	}
+}
+
+// start starts llama-server in its own process group, so that signals can be
+// forwarded to it (and any subprocesses it may spawn) with a single kill call.
+func (c *child) start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopping {
+		return errStopping
+	}
+	// A new process, or the failure to start one, is still to be reported.
+	c.exitReported = false
+	cmd := execCommand("llama-server", c.args...)
+	cmd.Stdout = c.stdout
+	cmd.Stderr = c.stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// Processes left behind by llama-server may hold its output open, which
+	// must not keep its exit from being handled.
+	cmd.WaitDelay = outputWaitDelay
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	c.cmd = cmd
+	c.startedAt = time.Now()
+	c.readyAt = time.Time{}
+	c.running = true
+	c.state = healthStarting
+	c.stoppedBy = ""
+	c.outputState = ""
+	c.loadTime = 0
+	return nil
+}
+
+// setState records the child's health state, and returns the previous one.
+func (c *child) setState(state healthState) healthState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous := c.state
+	c.state = state
+	if state == healthReady && c.readyAt.IsZero() {
+		c.readyAt = time.Now()
+	}
+	return previous
+}
+
+// healthyUptime returns how long the child's current process has run
+// healthy: since it first passed its health check, or since it started
+// without health checks.
+func (c *child) healthyUptime() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.health == nil {
+		return time.Since(c.startedAt)
+	}
+	if c.readyAt.IsZero() {
+		return 0
+	}
+	return time.Since(c.readyAt)
+}
+
+// pid returns the process ID of the child's current process, which is also
+// its process group ID.
+func (c *child) pid() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cmd == nil {
+		return 0
+	}
+	return c.cmd.Process.Pid
+}
+
+// runningPID returns the process ID of the child's current process, or 0 if
+// it is not running.
+func (c *child) runningPID() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running {
+		return 0
+	}
+	return c.cmd.Process.Pid
+}
+
+// setStoppedBy records what the child's current process was asked to stop by.
+func (c *child) setStoppedBy(by string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stoppedBy = by
+}
+
+// escalate records what the child's process pid is asked to stop by next,
+// and reports whether that process is still running, so that a signal is
+// not escalated once it has exited.
+func (c *child) escalate(pid int, by string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running || c.cmd.Process.Pid != pid {
+		return false
+	}
+	c.stoppedBy = by
+	return true
+}
+
+// markExitReported reports whether the exit of the child's last process is
+// still to be reported, and marks it as reported.
+func (c *child) markExitReported() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.exitReported {
+		return false
+	}
+	c.exitReported = true
+	return true
+}
+
+// stopStage returns what the child's last process was asked to stop by.
+func (c *child) stopStage() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stoppedBy
+}
+
+// errNotRunning is returned when signalling a child that has no running
+// process, e.g. while it waits to be restarted.
+var errNotRunning = errors.New("llama-server is not running")
+
+// signal sends a signal to the process group of the child's running process.
+// Negative PID means "process group". The process is checked under the
+// child's mutex, so that the group of a process that has exited, whose ID may
+// be reused, is never signalled.
+func (c *child) signal(sig syscall.Signal) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running {
+		return errNotRunning
+	}
+	return syscall.Kill(-c.cmd.Process.Pid, sig)
+}
+
+// signalProcess sends a signal like signal, but only if the child's running
+// process is still pid.
+func (c *child) signalProcess(pid int, sig syscall.Signal) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running || c.cmd.Process.Pid != pid {
+		return errNotRunning
+	}
+	return syscall.Kill(-pid, sig)
+}
+
+// markStopping keeps the child from being restarted, as it is being stopped.
+func (c *child) markStopping() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.stopping {
+		c.stopping = true
+		if c.stopped != nil {
+			close(c.stopped)
+		}
+	}
+}
+
+// sleep waits for d, and reports whether it did so without the child being
+// asked to stop.
+func (c *child) sleep(d time.Duration) bool {
+	c.mu.Lock()
+	if c.stopped == nil {
+		c.stopped = make(chan struct{})
+		if c.stopping {
+			close(c.stopped)
+		}
+	}
+	stopped := c.stopped
+	c.mu.Unlock()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stopped:
+		return false
+	}
+}
+
+// wait waits for the child to exit and returns the error from exec, which
+// describes how it exited.
+func (c *child) wait() error {
+	c.mu.Lock()
+	cmd := c.cmd
+	c.mu.Unlock()
+	err := cmd.Wait()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// llama-server itself exited successfully.
+		err = nil
+	}
+
+	code := exitCode(err)
+	c.mu.Lock()
+	c.running = false
+	c.lastExit = &code
+	c.mu.Unlock()
+
+	for _, w := range []io.Writer{c.stdout, c.stderr} {
+		if o, ok := w.(*outputWriter); ok {
+			o.Flush()
+		}
+	}
+	return err
+}
+
+// exitCode converts the result of running llama-server into an exit code.
+// This is the child's exit status, or 128+signal number if the child was
+// killed by a signal, following the shell convention.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		waitStatus := exitError.Sys().(syscall.WaitStatus)
+		if waitStatus.Signaled() {
+			return 128 + int(waitStatus.Signal())
+		}
+		return waitStatus.ExitStatus()
+	}
+	return 1
+}
+
+// exitSignal returns the name of the signal that killed llama-server, or ""
+// if it was not killed by a signal.
+func exitSignal(err error) string {
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		if waitStatus := exitError.Sys().(syscall.WaitStatus); waitStatus.Signaled() {
+			return signalName(waitStatus.Signal())
+		}
+	}
+	return ""
+}
+
+// describeExit describes the result of running llama-server, for messages.
+func describeExit(err error) string {
+	if err == nil {
+		return "llama-server exited successfully."
+	}
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		waitStatus := exitError.Sys().(syscall.WaitStatus)
+		if waitStatus.Signaled() {
+			return fmt.Sprintf("llama-server was killed by signal: %v", waitStatus.Signal())
+		}
+		return fmt.Sprintf("llama-server exited with status: %d", waitStatus.ExitStatus())
+	}
+	return fmt.Sprintf("Failed to run llama-server: %v", err)
+}
+
+// supervisor runs one or more llama-server processes, forwards signals to
+// all of them, and decides from their exit statuses when llauncher exits and
+// with what code.
+type supervisor struct {
+	children []*child
+	// replacing holds the children that are starting to replace, or being
+	// replaced by, one of children, in blue/green mode.
+	replacing []*child
+	policy    string
+	// onDemand is set in swap mode, where the children are started by the
+	// requests for their models.
+	onDemand  bool
+	startedAt time.Time
+	// output configures the output pipeline of every child.
+	output OutputConfig
+	// config and servers are the configuration the children were created
+	// or last reloaded from.
+	config  *LlamaConfig
+	servers []server
+	// reload loads the configuration again, if reloading is enabled, and
+	// changes delivers the configuration files that changed on disk.
+	reload  func() (*LlamaConfig, []server, error)
+	changes <-chan string
+	// mu guards configHash, which changes on reload, and the children in
+	// blue/green mode.
+	mu         sync.Mutex
+	configHash string
+	// outputMu serialises the output of the children, so that their lines
+	// never interleave. A blocked output only holds up other output, not
+	// the status or the reaper.
+	outputMu sync.Mutex
+}
+
+// newSupervisor creates a supervisor for the given servers. In multi-model
+// mode the output of each server is prefixed with its name.
+func newSupervisor(config *LlamaConfig, servers []server) *supervisor {
+	s := &supervisor{
+		policy:     config.ExitPolicy,
+		startedAt:  time.Now(),
+		config:     config,
+		servers:    servers,
+		configHash: configHash(servers),
+		output:     config.Output,
+	}
+	if s.policy == "" {
+		s.policy = exitPolicyAnyFails
+	}
+	for _, srv := range servers {
+		s.children = append(s.children, s.newChild(srv))
+	}
+	return s
+}
+
+// setChildren replaces the supervisor's children in blue/green mode, where
+// a reload replaces the running child.
+func (s *supervisor) setChildren(children, replacing []*child) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.children, s.replacing = children, replacing
+}
+
+// childResult is the outcome of running one child.
+type childResult struct {
+	child *child
+	err   error
+}
+
+// run starts every child and waits for them to exit, applying the exit
+// policy, and returns llauncher's exit code.
+func (s *supervisor) run() int {
+	// Buffered channel so we don't miss signals while children are starting.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, forwardedSignals...)
+	defer signal.Stop(sigChan)
+
+	results := make(chan childResult, len(s.children))
+	running := make(map[*child]bool)
+	for _, c := range s.children {
+		if err := c.start(); err != nil {
+			results <- childResult{child: c, err: err}
+			continue
+		}
+		running[c] = true
+		go func(c *child) {
+			results <- childResult{child: c, err: s.supervise(c)}
+		}(c)
+	}
+
+	code := 0
+	failures := 0
+	stopping := false
+	for remaining := len(s.children); remaining > 0; {
+		select {
+		case path := <-s.changes:
+			s.reloadConfig(path)
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP && s.reload != nil {
+				s.reloadConfig("SIGHUP")
+				continue
+			}
+			for c := range running {
+				s.logger(c).Debug("Forwarding signal to llama-server", "event", "signal", "pid", c.pid(), "signal", signalName(sig.(syscall.Signal)))
+				forward := c.signal
+				if isTermination(sig) {
+					c.markStopping()
+					forward = func(sig syscall.Signal) error { return s.terminate(c, sig) }
+				}
+				if err := forward(sig.(syscall.Signal)); err != nil {
+					s.logger(c).Debug("Failed to forward signal", "event", "signal", "pid", c.pid(), "signal", signalName(sig.(syscall.Signal)), "error", err)
+				}
+			}
+		case r := <-results:
+			remaining--
+			delete(running, r.child)
+			s.reportExit(r.child, r.err)
+			if r.err == nil {
+				continue
+			}
+			failures++
+			if code == 0 {
+				code = exitCode(r.err)
+			}
+			if s.policy == exitPolicyAnyFails && !stopping && len(running) > 0 {
+				// Stop the other servers, so that the orchestrator can
+				// restart llauncher as a whole.
+				stopping = true
+				for c := range running {
+					s.logger(c).Info("Stopping llama-server because another model failed", "event", "stop", "pid", c.pid(), "failed_model", r.child.name)
+					c.markStopping()
+					s.terminate(c, syscall.SIGTERM)
+				}
+			}
+		}
+	}
+
+	if s.policy == exitPolicyAllFail && failures < len(s.children) {
+		return 0
+	}
+	return code
+}
+
+// supervise waits for a started child to exit, restarting it as its restart
+// policy allows, and returns how it last exited.
+func (s *supervisor) supervise(c *child) error {
+	restarts := 0
+	for {
+		exited := make(chan struct{})
+		monitored := make(chan struct{})
+		if c.health != nil {
+			go func() {
+				defer close(monitored)
+				s.monitor(c, exited)
+			}()
+		} else {
+			close(monitored)
+		}
+		err := c.wait()
+		close(exited)
+		// A probe in flight finishes before the exit is handled.
+		<-monitored
+
+		c.mu.Lock()
+		stopping := c.stopping
+		c.mu.Unlock()
+		uptime := c.healthyUptime()
+		if stopping {
+			return err
+		}
+		if c.applyReload() {
+			// The server was stopped to start it with its new command.
+			s.reportExit(c, err)
+			restarts = 0
+			if startErr := c.start(); startErr != nil {
+				if errors.Is(startErr, errStopping) {
+					return err
+				}
+				return startErr
+			}
+			continue
+		}
+		if !c.restart.shouldRestart(err) {
+			return err
+		}
+
+		// A server that ran healthy long enough starts a new series of
+		// restarts.
+		if uptime >= c.restart.resetAfter {
+			restarts = 0
+		}
+		restarts++
+		exit := strings.TrimSuffix(describeExit(err), ".")
+		attrs := []any{"event", "restart", "pid", c.pid(), "exit_code", exitCode(err)}
+		if sig := exitSignal(err); sig != "" {
+			attrs = append(attrs, "signal", sig)
+		}
+		if c.restart.maxRetries > 0 && restarts > c.restart.maxRetries {
+			s.logger(c).Error(exit+", giving up", append(attrs, "restarts", c.restart.maxRetries)...)
+			return err
+		}
+
+		delay := c.restart.delay(restarts)
+		attrs = append(attrs, "restart", restarts, "delay", delay.Round(time.Millisecond).String())
+		if c.restart.maxRetries > 0 {
+			attrs = append(attrs, "max_retries", c.restart.maxRetries)
+		}
+		s.logger(c).Warn(exit+", restarting", attrs...)
+		if !c.sleep(delay) {
+			return err
+		}
+		c.applyReload()
+		if startErr := c.start(); startErr != nil {
+			if errors.Is(startErr, errStopping) {
+				return err
+			}
+			return startErr
+		}
+		c.mu.Lock()
+		c.restarts++
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// healthPollInterval is how often a starting llama-server is asked whether
+// it has loaded its model.
+const healthPollInterval = 250 * time.Millisecond
+
+// swapLoad is one run of a llama-server in swap mode, from the moment it is
+// requested until it exits.
+type swapLoad struct {
+	upstream *upstream
+	child    *child
+	// started is closed once the server has been started, or it has been
+	// decided not to start it.
+	started chan struct{}
+	// ready is closed once the server is healthy, or failed to start, in
+	// which case err is set.
+	ready chan struct{}
+	err   error
+	// exited is closed when the server has exited, with exitErr describing
+	// how.
+	exited  chan struct{}
+	exitErr error
+	// stopOnce ensures the server is only asked to stop once.
+	stopOnce sync.Once
+}
+
+// swapper runs one llama-server at a time behind the routing proxy. The
+// server for a model is started by the first request for it, after stopping
+// the previous one, and is stopped again once it has been idle for a while.
+// Requests wait while their model loads.
+type swapper struct {
+	sup      *supervisor
+	children map[*upstream]*child
+	client   *http.Client
+	idleTTL  time.Duration
+
+	mu sync.Mutex
+	// active is the server that requests are sent to, which may still be
+	// loading. last is the most recently started server, which may still be
+	// running after active is cleared, while it is being stopped.
+	active *swapLoad
+	last   *swapLoad
+	// inflight counts the requests being served by the active server, and
+	// pending the requests waiting for a different model.
+	inflight int
+	pending  int
+	// changed is closed, and replaced, whenever the state above changes.
+	changed   chan struct{}
+	idleTimer *time.Timer
+	closed    bool
+}
+
+// newSwapper creates a swapper for the children of a supervisor, which are
+// reached through the upstreams of a routing proxy. A zero idleTTL keeps the
+// last server running until another model is requested.
+func newSwapper(sup *supervisor, proxy *routingProxy, idleTTL time.Duration) *swapper {
+	sup.onDemand = true
+	s := &swapper{
+		sup:      sup,
+		children: make(map[*upstream]*child),
+		client:   proxy.client,
+		idleTTL:  idleTTL,
+		changed:  make(chan struct{}),
+	}
+	// A server's health is always checked before requests are sent to it,
+	// which its status reports.
+	for i, u := range proxy.upstreams {
+		c := sup.children[i]
+		c.mu.Lock()
+		c.health = u.health
+		c.mu.Unlock()
+		s.children[u] = c
+	}
+	return s
+}
+
+// broadcast wakes every request waiting for a change. It must be called with
+// the lock held.
+func (s *swapper) broadcast() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// wait releases the lock until the state changes or ctx is done, and returns
+// with the lock held.
+func (s *swapper) wait(ctx context.Context, ch <-chan struct{}) error {
+	s.mu.Unlock()
+	defer s.mu.Lock()
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// acquire makes the server for u the active one, starting it if needed, and
+// waits until it is ready to serve. The returned function must be called
+// once the request has been served.
+func (s *swapper) acquire(ctx context.Context, u *upstream) (func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// waiting is set while the request waits for a different model, and
+	// queued once it has waited for its own model to load.
+	waiting, queued := false, false
+	defer func() {
+		if waiting {
+			s.pending--
+			s.broadcast()
+		}
+	}()
+
+	for {
+		if s.closed {
+			return nil, fmt.Errorf("llauncher is shutting down")
+		}
+		load := s.active
+
+		if load != nil && load.upstream == u {
+			if !isDone(load.ready) {
+				// Queue behind the server while it loads.
+				if err := s.wait(ctx, load.ready); err != nil {
+					return nil, err
+				}
+				if load.err != nil {
+					return nil, load.err
+				}
+				queued = true
+				continue
+			}
+			// Let requests for other models go first once they are
+			// waiting, so that a busy model cannot starve them. Requests
+			// that waited for the model to load are served first.
+			if s.pending > 0 && !waiting && !queued {
+				if err := s.wait(ctx, s.changed); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			s.inflight++
+			if s.idleTimer != nil {
+				s.idleTimer.Stop()
+			}
+			return s.release, nil
+		}
+
+		if load == nil || (isDone(load.ready) && s.inflight == 0) {
+			s.switchTo(u)
+			continue
+		}
+
+		// Wait for the active server to load and finish its requests.
+		if !waiting {
+			waiting = true
+			s.pending++
+		}
+		if err := s.wait(ctx, s.changed); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// isDone reports whether a channel has been closed.
+func isDone(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// release ends a request on the active server, and starts the idle timer
+// once it has none left.
+func (s *swapper) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inflight--
+	if s.inflight == 0 {
+		if s.idleTTL > 0 && s.active != nil {
+			load := s.active
+			s.idleTimer = time.AfterFunc(s.idleTTL, func() { s.unload(load) })
+		}
+		s.broadcast()
+	}
+}
+
+// switchTo makes a new server for u the active one, and loads it in the
+// background after stopping the previous server. It must be called with the
+// lock held.
+func (s *swapper) switchTo(u *upstream) {
+	load := &swapLoad{
+		upstream: u,
+		child:    s.children[u],
+		started:  make(chan struct{}),
+		ready:    make(chan struct{}),
+		exited:   make(chan struct{}),
+	}
+	previous := s.last
+	s.active = load
+	s.last = load
+	s.broadcast()
+	go s.load(previous, load)
+}
+
+// load stops the previous server, if any, then starts the new one and waits
+// for it to become healthy.
+func (s *swapper) load(previous, load *swapLoad) {
+	if previous != nil {
+		s.stop(previous, syscall.SIGTERM, fmt.Sprintf("to load %s", load.child.name))
+	}
+
+	s.mu.Lock()
+	var err error
+	if s.closed {
+		err = fmt.Errorf("llauncher is shutting down")
+	} else {
+		err = load.child.start()
+		if err == nil {
+			s.sup.logger(load.child).Info("Started llama-server", "event", "start", "pid", load.child.pid())
+		}
+	}
+	s.mu.Unlock()
+	close(load.started)
+
+	if err == nil {
+		go func() {
+			load.exitErr = load.child.wait()
+			close(load.exited)
+			s.exited(load)
+		}()
+		err = s.waitHealthy(load)
+	} else {
+		close(load.exited)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		load.err = fmt.Errorf("model %q could not be loaded: %w", load.upstream.alias, err)
+		s.sup.logger(load.child).Error("Model could not be loaded", "event", "start", "error", err)
+		if s.active == load {
+			s.active = nil
+		}
+	}
+	close(load.ready)
+	s.broadcast()
+}
+
+// waitHealthy polls a starting server's /health endpoint until it reports
+// that the model is loaded, or the server exits or exceeds its startup
+// timeout, in which case it is killed.
+func (s *swapper) waitHealthy(load *swapLoad) error {
+	h := load.upstream.health
+	ticker := time.NewTicker(healthPollInterval)
+	defer ticker.Stop()
+	var startupTimeout <-chan time.Time
+	if h.startupTimeout > 0 {
+		timer := time.NewTimer(h.startupTimeout)
+		defer timer.Stop()
+		startupTimeout = timer.C
+	}
+	for {
+		state, _ := h.probe(context.Background())
+		if state == healthReady {
+			load.child.setState(state)
+			return nil
+		}
+		if state == healthUnhealthy {
+			// An error before the model is loaded is part of loading.
+			state = healthLoading
+		}
+		load.child.setState(state)
+		select {
+		case <-load.exited:
+			return fmt.Errorf("%s", describeExit(load.exitErr))
+		case <-startupTimeout:
+			load.child.signal(syscall.SIGKILL)
+			<-load.exited
+			return fmt.Errorf("not ready within %s", h.startupTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// exited handles the exit of a server. If it was not asked to stop, the next
+// request for its model starts it again.
+func (s *swapper) exited(load *swapLoad) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active != load {
+		return
+	}
+	// While the server is loading, load reports the failure instead.
+	if isDone(load.ready) {
+		s.sup.reportExit(load.child, load.exitErr)
+		s.active = nil
+		s.broadcast()
+	}
+}
+
+// unload stops a server that has been idle for the idle TTL, unless it has
+// been used or replaced since.
+func (s *swapper) unload(load *swapLoad) {
+	s.mu.Lock()
+	if s.active != load || s.inflight > 0 || s.pending > 0 {
+		s.mu.Unlock()
+		return
+	}
+	s.active = nil
+	s.broadcast()
+	s.mu.Unlock()
+
+	s.stop(load, syscall.SIGTERM, fmt.Sprintf("after being idle for %s", s.idleTTL))
+}
+
+// stop sends a signal to a server's process group, and waits for it to exit.
+func (s *swapper) stop(load *swapLoad, sig syscall.Signal, reason string) {
+	<-load.started
+	load.stopOnce.Do(func() {
+		if isDone(load.exited) {
+			return
+		}
+		s.sup.logger(load.child).Info("Stopping llama-server "+reason, "event", "stop", "pid", load.child.pid())
+		if err := s.sup.terminate(load.child, sig); err != nil {
+			s.sup.logger(load.child).Debug("Failed to send signal", "event", "signal", "signal", signalName(sig), "error", err)
+		}
+	})
+	<-load.exited
+}
+
+// shutdown refuses further requests, stops the running server with the given
+// signal, and returns llauncher's exit code.
+func (s *swapper) shutdown(sig syscall.Signal) int {
+	s.mu.Lock()
+	s.closed = true
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	last := s.last
+	s.broadcast()
+	s.mu.Unlock()
+
+	if last == nil {
+		return 0
+	}
+	s.stop(last, sig, fmt.Sprintf("on signal %v", sig))
+	s.sup.reportExit(last.child, last.exitErr)
+	return exitCode(last.exitErr)
+}
+
+// forward sends a signal to the running server, if any.
+func (s *swapper) forward(sig syscall.Signal) {
+	s.mu.Lock()
+	last := s.last
+	s.mu.Unlock()
+	if last == nil {
+		return
+	}
+	if !isDone(last.started) || isDone(last.exited) {
+		return
+	}
+	s.sup.logger(last.child).Debug("Forwarding signal to llama-server", "event", "signal", "pid", last.child.pid(), "signal", signalName(sig))
+	last.child.signal(sig)
+}
+
+// runSwap runs the servers of a configuration in swap mode, behind the
+// routing proxy, until llauncher is told to stop.
+func runSwap(config *LlamaConfig, sup *supervisor, servers []server) int {
+	// Subscribe before the proxy starts, so that no signal is missed.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, forwardedSignals...)
+	defer signal.Stop(sigChan)
+
+	idleTTL := parseDurationOr(config.Proxy.IdleTTL, 0)
+	proxy := newRoutingProxy(servers)
+	proxy.swap = newSwapper(sup, proxy, idleTTL)
+
+	stop, err := startProxy(config.Proxy.Listen, proxy)
+	if err != nil {
+		slog.Error("Could not start the routing proxy", "event", "listen", "error", err)
+		return 1
+	}
+	defer stop()
+	slog.Debug("Routing proxy listening in swap mode", "event", "listen", "address", config.Proxy.Listen)
+
+	for sig := range sigChan {
+		if isTermination(sig) {
+			return proxy.swap.shutdown(sig.(syscall.Signal))
+		}
+		proxy.swap.forward(sig.(syscall.Signal))
+	}
+	return 0
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configError describes a single problem with a configuration. The position
+// is filled in when the problem can be traced back to a location in a YAML file.
+type configError struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error formats the problem as "file:line:column: key: message", leaving out
+// any parts that are unknown.
+func (e configError) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File)
+		if e.Line > 0 {
+			fmt.Fprintf(&b, ":%d:%d", e.Line, e.Column)
+		}
+		b.WriteString(": ")
+	} else if e.Line > 0 {
+		fmt.Fprintf(&b, "line %d, column %d: ", e.Line, e.Column)
+	}
+	if e.Key != "" {
+		b.WriteString(e.Key)
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Message)
+	return b.String()
+}
+
+// configErrors aggregates all the problems found in a configuration, so they
+// can be reported together rather than one at a time.
+type configErrors []configError
+
+// Error lists each problem on its own line.
+func (e configErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, ce := range e {
+		lines[i] = ce.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkKnownKeys walks a YAML mapping node and reports every key that does not
+// match a `yaml` tag of the given struct type, recursing into nested structs
+// and lists of structs. Each report carries the key's position and, where a
+// known key is close enough, a suggested replacement.
+func checkKnownKeys(node *yaml.Node, typ reflect.Type, file string, prefix string) configErrors {
+	var errs configErrors
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	fields := yamlFields(typ)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		field, ok := fields[key.Value]
+		if !ok {
+			msg := "unknown key"
+			if suggestion := suggestKey(key.Value, fields); suggestion != "" {
+				msg += fmt.Sprintf(", did you mean %q?", prefix+suggestion)
+			}
+			errs = append(errs, configError{
+				File:    file,
+				Line:    key.Line,
+				Column:  key.Column,
+				Key:     prefix + key.Value,
+				Message: msg,
+			})
+			continue
+		}
+
+		fieldType := field.Type
+		switch {
+		case fieldType.Kind() == reflect.Struct:
+			errs = append(errs, checkKnownKeys(value, fieldType, file, prefix+key.Value+".")...)
+		case fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() == reflect.Struct:
+			if value.Kind != yaml.SequenceNode {
+				continue
+			}
+			for j, item := range value.Content {
+				errs = append(errs, checkKnownKeys(item, fieldType.Elem(), file, fmt.Sprintf("%s%s[%d].", prefix, key.Value, j))...)
+			}
+		}
+	}
+	return errs
+}
+
+// yamlFields maps the `yaml` tag names of a struct type to their fields.
+func yamlFields(typ reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// suggestKey returns the known key closest to an unknown one, or "" if none is
+// close enough to be a plausible typo. Underscores are treated as dashes, so
+// "ctx_size" suggests "ctx-size".
+func suggestKey(unknown string, fields map[string]reflect.StructField) string {
+	normalized := strings.ToLower(strings.ReplaceAll(unknown, "_", "-"))
+	best, bestDist := "", -1
+	for name := range fields {
+		dist := levenshtein(normalized, name)
+		if bestDist < 0 || dist < bestDist || (dist == bestDist && name < best) {
+			best, bestDist = name, dist
+		}
+	}
+
+	// Allow roughly one edit per three characters, and at least two.
+	maxDist := len(normalized) / 3
+	if maxDist < 2 {
+		maxDist = 2
+	}
+	if bestDist < 0 || bestDist > maxDist {
+		return ""
+	}
+	return best
+}
+
+// levenshtein returns the edit distance between two strings.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// exclusiveOptions lists pairs of options that cannot both be set, whatever
+// their values, as an explicit false may also be passed to llama-server.
+var exclusiveOptions = [][2]string{
+	{"cont-batching", "no-cont-batching"},
+	{"escape", "no-escape"},
+	{"slots", "no-slots"},
+	{"context-shift", "no-context-shift"},
+	{"grammar", "grammar-file"},
+	{"json-schema", "json-schema-file"},
+}
+
+// modelSources lists the options that each tell llama-server where to get the
+// model from. At most one of them may be set.
+var modelSources = []string{"model", "model-url", "hf-repo"}
+
+// enumOptions lists the accepted values of options that take one of a fixed
+// set of values, as of the llama-server version llauncher targets.
+var enumOptions = map[string][]string{
+	"cache-type-k":       {"f32", "f16", "bf16", "q8_0", "q4_0", "q4_1", "iq4_nl", "q5_0", "q5_1"},
+	"cache-type-v":       {"f32", "f16", "bf16", "q8_0", "q4_0", "q4_1", "iq4_nl", "q5_0", "q5_1"},
+	"cache-type-k-draft": {"f32", "f16", "bf16", "q8_0", "q4_0", "q4_1", "iq4_nl", "q5_0", "q5_1"},
+	"cache-type-v-draft": {"f32", "f16", "bf16", "q8_0", "q4_0", "q4_1", "iq4_nl", "q5_0", "q5_1"},
+	"split-mode":         {"none", "layer", "row"},
+	"numa":               {"distribute", "isolate", "numactl"},
+	"rope-scaling":       {"none", "linear", "yarn"},
+	"pooling":            {"none", "mean", "cls", "last", "rank"},
+	"reasoning-format":   {"none", "deepseek", "deepseek-legacy", "auto"},
+	"flash-attn":         {"on", "off", "auto"},
+}
+
+// fileOptions lists the options naming local files that must exist before
+// llama-server is started.
+var fileOptions = []string{
+	"model",
+	"model-draft",
+	"mmproj",
+	"lora",
+	"grammar-file",
+	"json-schema-file",
+	"chat-template-file",
+}
+
+// validateConfig checks a configuration for contradictions and bad values
+// that the YAML parser cannot catch, so they are reported before llama-server
+// is started. All problems found are returned together as configErrors.
+func validateConfig(config *LlamaConfig) error {
+	return validateConfigFiles(config, true)
+}
+
+// validateConfigFiles checks a configuration like validateConfig, but only
+// checks that local files exist if checkFiles is set, e.g. to validate a
+// configuration in CI, away from the machine with the models.
+func validateConfigFiles(config *LlamaConfig, checkFiles bool) error {
+	var errs configErrors
+	if len(config.Models) > 0 {
+		// The top-level options are inherited by, and checked with, each
+		// model.
+		if value := config.ExitPolicy; value != "" && !slices.Contains(exitPolicies, value) {
+			errs = append(errs, configError{
+				Key:     "exit-policy",
+				Message: fmt.Sprintf("invalid value %q, must be one of: %s", value, strings.Join(exitPolicies, ", ")),
+			})
+		}
+		errs = append(errs, validateModels(config, checkFiles)...)
+	} else {
+		errs = validateServer(config, checkFiles)
+		if config.Proxy != (ProxyConfig{}) {
+			errs = append(errs, configError{
+				Key:     "proxy",
+				Message: "can only be used with a models list",
+			})
+		}
+	}
+
+	errs = append(errs, validateStatus(config)...)
+	errs = append(errs, validateOutput(config.Output)...)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateServer checks the options of a single llama-server instance, and
+// that the local files it names exist if checkFiles is set.
+func validateServer(config *LlamaConfig, checkFiles bool) configErrors {
+	var errs configErrors
+	values := configValues(config)
+
+	for _, pair := range exclusiveOptions {
+		if isGiven(config, values, pair[0]) && isGiven(config, values, pair[1]) {
+			errs = append(errs, configError{
+				Key:     pair[0],
+				Message: fmt.Sprintf("cannot be used together with %s", pair[1]),
+			})
+		}
+	}
+
+	var sources []string
+	for _, key := range modelSources {
+		if isEnabled(values[key]) {
+			sources = append(sources, key)
+		}
+	}
+	if len(sources) > 1 {
+		errs = append(errs, configError{
+			Key:     sources[0],
+			Message: fmt.Sprintf("only one model source may be set, found %s", strings.Join(sources, ", ")),
+		})
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(enumOptions)) {
+		value := values[key].String()
+		if value != "" && !slices.Contains(enumOptions[key], value) {
+			errs = append(errs, configError{
+				Key:     key,
+				Message: fmt.Sprintf("invalid value %q, must be one of: %s", value, strings.Join(enumOptions[key], ", ")),
+			})
+		}
+	}
+
+	if config.Port != 0 || config.isSet("port") {
+		if config.Port < 1 || config.Port > 65535 {
+			errs = append(errs, configError{
+				Key:     "port",
+				Message: fmt.Sprintf("invalid port %d, must be between 1 and 65535", config.Port),
+			})
+		}
+	}
+
+	errs = append(errs, validateRestart(config.Restart)...)
+	errs = append(errs, validateHealth(config.Health)...)
+	errs = append(errs, validateShutdown(config)...)
+
+	if isGiven(config, values, "draft-min") && isGiven(config, values, "draft-max") && config.DraftMin > config.DraftMax {
+		errs = append(errs, configError{
+			Key:     "draft-min",
+			Message: fmt.Sprintf("%d is greater than draft-max %d", config.DraftMin, config.DraftMax),
+		})
+	}
+
+	if !checkFiles {
+		return errs
+	}
+	for _, key := range fileOptions {
+		value := values[key]
+		var paths []string
+		if value.Kind() == reflect.Slice {
+			for i := 0; i < value.Len(); i++ {
+				paths = append(paths, value.Index(i).String())
+			}
+		} else if value.String() != "" {
+			paths = append(paths, value.String())
+		}
+		for _, path := range paths {
+			if _, err := os.Stat(path); err != nil {
+				errs = append(errs, configError{
+					Key:     key,
+					Message: fmt.Sprintf("file %q does not exist", path),
+				})
+			}
+		}
+	}
+	return errs
+}
+
+// configValues maps the `yaml` tag names of a configuration to field values.
+func configValues(config *LlamaConfig) map[string]reflect.Value {
+	values := make(map[string]reflect.Value)
+	val := reflect.ValueOf(config).Elem()
+	for name, field := range yamlFields(val.Type()) {
+		values[name] = val.FieldByIndex(field.Index)
+	}
+	return values
+}
+
+// isEnabled reports whether a configuration value is set to something other
+// than its zero value, i.e. a true bool, non-empty string or non-zero number.
+func isEnabled(value reflect.Value) bool {
+	return value.IsValid() && !value.IsZero()
+}
+
+// isGiven reports whether a key is explicitly set in a configuration, even to
+// its zero value, or has a value other than its zero value.
+func isGiven(config *LlamaConfig, values map[string]reflect.Value, key string) bool {
+	return config.isSet(key) || isEnabled(values[key])
+}
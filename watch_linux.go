@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// watchDebounce is how long the configuration files must be left alone after
+// a change before they are read, as editors and ConfigMap updates write them
+// in several steps.
+const watchDebounce = 200 * time.Millisecond
+
+// watchMask selects the inotify events that may change a configuration file:
+// writing it in place, replacing it by a rename, as editors do, or swapping
+// the symlink it is reached through, as Kubernetes does for ConfigMaps.
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_DELETE
+
+// watchFiles watches the directories of the given files with inotify, and
+// sends the path of a file on the returned channel when its contents have
+// changed. It returns a function that stops watching.
+func watchFiles(paths []string) (<-chan string, func(), error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not watch the configuration: %w", err)
+	}
+	// A non-blocking file is read through the runtime's poller, so closing
+	// it ends a pending read.
+	events := os.NewFile(uintptr(fd), "inotify")
+
+	var dirs []string
+	for _, path := range paths {
+		dir, err := filepath.Abs(filepath.Dir(path))
+		if err != nil || slices.Contains(dirs, dir) {
+			continue
+		}
+		if _, err := syscall.InotifyAddWatch(fd, dir, watchMask); err != nil {
+			events.Close()
+			return nil, nil, fmt.Errorf("could not watch %s: %w", dir, err)
+		}
+		dirs = append(dirs, dir)
+	}
+
+	sums := make(map[string][32]byte)
+	for _, path := range paths {
+		sums[path] = fileSum(path)
+	}
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			if _, err := events.Read(buf); err != nil {
+				close(changed)
+				return
+			}
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	changes := make(chan string)
+	stop := make(chan struct{})
+	go func() {
+		var settled <-chan time.Time
+		for {
+			select {
+			case _, ok := <-changed:
+				if !ok {
+					return
+				}
+				settled = time.After(watchDebounce)
+				continue
+			case <-settled:
+			}
+			for _, path := range paths {
+				sum := fileSum(path)
+				if sum == sums[path] {
+					continue
+				}
+				sums[path] = sum
+				select {
+				case changes <- path:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	return changes, func() {
+		close(stop)
+		events.Close()
+	}, nil
+}
+
+// fileSum returns the checksum of a file's contents, or of nothing if it
+// cannot be read, e.g. while it is being replaced.
+func fileSum(path string) [32]byte {
+	data, _ := os.ReadFile(path)
+	return sha256.Sum256(data)
+}
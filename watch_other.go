@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// watchFiles is only supported on Linux, where it uses inotify.
+func watchFiles(paths []string) (<-chan string, func(), error) {
+	return nil, nil, errors.New("watching the configuration is only supported on Linux")
+}